@@ -0,0 +1,154 @@
+package authclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SessionRequest opens a session for a user whose credentials were checked.
+type SessionRequest struct {
+	UserID      int      `json:"user_id"`
+	Email       string   `json:"email"`
+	Device      string   `json:"device"`
+	IP          string   `json:"ip"`
+	UserAgent   string   `json:"user_agent"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// Tokens are issued when a session is opened or refreshed.
+type Tokens struct {
+	Email            string `json:"email"`
+	Token            string `json:"token"`
+	SessionID        string `json:"session_id"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
+// Session is a live session of a user.
+type Session struct {
+	ID          string    `json:"id"`
+	UserID      int       `json:"user_id"`
+	Email       string    `json:"email"`
+	Device      string    `json:"device"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Roles       []string  `json:"roles,omitempty"`
+	Permissions []string  `json:"permissions,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Current flags the session named when listing.
+	Current bool `json:"current"`
+}
+
+// Login events reported around a password check.
+const (
+	LoginAttempted = "attempt"
+	LoginFailed    = "failed"
+	LoginSucceeded = "succeeded"
+)
+
+type loginEvent struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+// CreateSession opens a session and returns its tokens.
+func (c *Client) CreateSession(ctx context.Context, session SessionRequest) (*Tokens, error) {
+	var tokens Tokens
+	err := c.do(ctx, request{method: http.MethodPost, path: "/sessions", body: session}, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokens, nil
+}
+
+// RefreshToken exchanges a refresh token for new tokens. A refresh token that
+// was already used revokes its session and fails with ErrUnauthorized.
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	payload := map[string]string{"refresh_token": refreshToken}
+
+	var tokens Tokens
+	err := c.do(ctx, request{method: http.MethodPost, path: "/token/refresh", body: payload}, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokens, nil
+}
+
+// ListSessions returns the live sessions of email, flagging current as such.
+func (c *Client) ListSessions(ctx context.Context, email string, current string) ([]*Session, error) {
+	payload := map[string]string{"email": email, "session_id": current}
+
+	sessions := []*Session{}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/sessions", body: payload, idempotent: true}, &sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Session returns the live session id of email. A session that ended, here
+// or through another client, fails with ErrNotFound.
+func (c *Client) Session(ctx context.Context, email string, id string) (*Session, error) {
+	payload := map[string]string{"email": email}
+
+	var session Session
+	err := c.do(ctx, request{method: http.MethodGet, path: "/sessions/" + url.PathEscape(id), body: payload, idempotent: true}, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// RevokeSession ends the session id of email.
+func (c *Client) RevokeSession(ctx context.Context, email string, id string) error {
+	c.revocations.session(id)
+
+	payload := map[string]string{"email": email}
+
+	return c.do(ctx, request{method: http.MethodDelete, path: "/sessions/" + url.PathEscape(id), body: payload, idempotent: true}, nil)
+}
+
+// RevokeAllSessions ends every session of email but the session id except,
+// when not empty, and returns how many were ended.
+func (c *Client) RevokeAllSessions(ctx context.Context, email string, except string) (int, error) {
+	c.revocations.all(email, except)
+
+	payload := map[string]string{"email": email, "except": except}
+
+	var result struct {
+		Revoked int `json:"revoked"`
+	}
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/sessions", body: payload, idempotent: true}, &result)
+
+	return result.Revoked, err
+}
+
+// Revoked reports whether an access token of the session id of email, issued
+// at issuedAt, belongs to a session ended through this client since. Access
+// tokens are verified by their signature alone, this refuses those of
+// sessions ended here before they expire.
+func (c *Client) Revoked(email string, sessionID string, issuedAt time.Time) bool {
+	return c.revocations.revoked(email, sessionID, issuedAt)
+}
+
+// ReportLogin reports a password login of email from ip. event is
+// LoginAttempted before the password is checked, which fails with
+// ErrTooManyRequests when the login is refused, then LoginFailed or
+// LoginSucceeded.
+func (c *Client) ReportLogin(ctx context.Context, event string, email string, ip string) error {
+	payload := loginEvent{Email: email, IP: ip}
+
+	return c.do(ctx, request{method: http.MethodPost, path: "/login/" + event, body: payload}, nil)
+}
@@ -0,0 +1,95 @@
+package authclient
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker stops calls to the service once threshold calls in a row failed,
+// so callers fail fast instead of each waiting for a timeout. After cooldown
+// a single probe call is let through, closing the breaker again when it
+// succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may go out, and whether it is the probe of a
+// breaker that cooled down.
+func (b *breaker) allow() (ok bool, probe bool) {
+	if b.threshold <= 0 {
+		return true, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false, false
+	}
+
+	b.probing = true
+	return true, true
+}
+
+// done records the outcome of a call let through by allow.
+func (b *breaker) done(probe bool, failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		// a failed probe starts another cooldown
+		b.openedAt = time.Now()
+	}
+}
+
+// release records no outcome for a call let through by allow that its caller
+// gave up on, which says nothing about the service. A probe lets another call
+// probe instead.
+func (b *breaker) release(probe bool) {
+	if b.threshold <= 0 || !probe {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// open reports whether calls are currently refused.
+func (b *breaker) open() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.threshold && time.Since(b.openedAt) < b.cooldown
+}
@@ -0,0 +1,96 @@
+package authclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testCooldown = 20 * time.Millisecond
+
+func TestBreakerTransitions(t *testing.T) {
+	b := newBreaker(2, testCooldown)
+
+	steps := []struct {
+		name      string
+		wait      time.Duration
+		wantAllow bool
+		wantProbe bool
+		failed    bool
+		wantOpen  bool
+	}{
+		{name: "closed, first failure", wantAllow: true, failed: true},
+		{name: "closed, second failure opens", wantAllow: true, failed: true, wantOpen: true},
+		{name: "open refuses", wantOpen: true},
+		{name: "half-open probe fails", wait: testCooldown, wantAllow: true, wantProbe: true, failed: true, wantOpen: true},
+		{name: "open again refuses", wantOpen: true},
+		{name: "half-open probe succeeds", wait: testCooldown, wantAllow: true, wantProbe: true},
+		{name: "closed again", wantAllow: true},
+	}
+
+	for _, step := range steps {
+		time.Sleep(step.wait)
+
+		ok, probe := b.allow()
+		if ok != step.wantAllow || probe != step.wantProbe {
+			t.Fatalf("%s: allow() = %v, %v, want %v, %v", step.name, ok, probe, step.wantAllow, step.wantProbe)
+		}
+		if ok {
+			b.done(probe, step.failed)
+		}
+		if got := b.open(); got != step.wantOpen {
+			t.Fatalf("%s: open() = %v, want %v", step.name, got, step.wantOpen)
+		}
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	b := newBreaker(1, testCooldown)
+	b.allow()
+	b.done(false, true)
+	time.Sleep(testCooldown)
+
+	if ok, probe := b.allow(); !ok || !probe {
+		t.Fatalf("allow() = %v, %v after the cooldown, want a probe", ok, probe)
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatal("a second call went out while probing")
+	}
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(block)
+
+	client := New(server.URL, Options{Retries: -1, BreakerThreshold: 1, BreakerCooldown: testCooldown})
+	client.breaker.allow()
+	client.breaker.done(false, true)
+	time.Sleep(testCooldown)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := client.do(ctx, request{method: http.MethodGet, path: "/"}, nil)
+	if !errors.Is(err, ErrUnavailable) || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe error = %v, want an unavailable service", err)
+	}
+
+	if client.breaker.failures != 1 {
+		t.Errorf("failures = %d after a cancelled probe, want 1", client.breaker.failures)
+	}
+	if client.breaker.probing {
+		t.Error("cancelled probe kept the probe slot")
+	}
+	if ok, probe := client.breaker.allow(); !ok || !probe {
+		t.Errorf("allow() = %v, %v after a cancelled probe, want another probe", ok, probe)
+	}
+}
@@ -0,0 +1,340 @@
+// Package authclient is the Go client of the authentication service. Services
+// use it to open and end sessions, refresh and check tokens and report
+// password logins for rate limiting.
+//
+// A Client is safe for concurrent use and reuses its connections, so a
+// service should create one and share it.
+package authclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 2
+	defaultBackoff = 100 * time.Millisecond
+	maxBackoff     = 2 * time.Second
+
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+
+	defaultRevocationTTL = 15 * time.Minute
+)
+
+var (
+	// ErrUnauthorized is matched by errors for credentials the service refused.
+	ErrUnauthorized = errors.New("authclient: unauthorized")
+	// ErrUnavailable is matched by errors for a service that could not be
+	// reached or failed to answer, after retrying.
+	ErrUnavailable = errors.New("authclient: authentication service unavailable")
+	// ErrTooManyRequests is matched by errors for a rate limited or locked
+	// out client. The *Error carries the Retry-After header.
+	ErrTooManyRequests = errors.New("authclient: too many requests")
+	// ErrNotFound is matched by errors for a session that does not exist or
+	// ended.
+	ErrNotFound = errors.New("authclient: not found")
+	// ErrCircuitOpen is matched, together with ErrUnavailable, by errors for
+	// calls refused without trying while the service keeps failing.
+	ErrCircuitOpen = errors.New("authclient: circuit open")
+)
+
+// Error is a reply of the authentication service other than the success
+// status of the endpoint. Use errors.Is with the Err values to classify it.
+type Error struct {
+	Status  int
+	Message string
+	Header  http.Header
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("authclient: authentication service replied %d", e.Status)
+	}
+
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	case ErrTooManyRequests:
+		return e.Status == http.StatusTooManyRequests
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrUnavailable:
+		return e.Status >= http.StatusInternalServerError
+	}
+
+	return false
+}
+
+// unavailableError wraps the last failure of a request that could not get an
+// answer from the service.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnavailable, e.err)
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *unavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// Options tunes a Client. Zero values pick the defaults.
+type Options struct {
+	// Timeout bounds each attempt of a request, 5s by default.
+	Timeout time.Duration
+	// Retries is how many times a failed attempt is retried, 2 by default.
+	// A negative value disables retries.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for every further
+	// one with some jitter, 100ms by default.
+	Backoff time.Duration
+	// HTTPClient sends the requests, by default one with its own pool of
+	// keep-alive connections.
+	HTTPClient *http.Client
+	// BreakerThreshold is how many calls in a row may fail before calls are
+	// refused with ErrCircuitOpen, 5 by default. A negative value disables
+	// the circuit breaker.
+	BreakerThreshold int
+	// BreakerCooldown is how long calls are refused before one is tried
+	// again, 10s by default.
+	BreakerCooldown time.Duration
+	// ServiceKey is sent in the X-Service-Key header of every request. The
+	// service refuses to open, look up, list or end sessions, to check
+	// session tokens and to count logins without it.
+	ServiceKey string
+	// RevocationTTL is how long sessions ended through the client are
+	// remembered by Revoked. It should cover the lifetime of access tokens,
+	// 15m by default.
+	RevocationTTL time.Duration
+}
+
+type Client struct {
+	baseURL     string
+	options     Options
+	http        *http.Client
+	breaker     *breaker
+	revocations *revocations
+}
+
+// New returns a client of the authentication service at baseURL.
+func New(baseURL string, options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.Retries == 0 {
+		options.Retries = defaultRetries
+	}
+	if options.Retries < 0 {
+		options.Retries = 0
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = defaultBackoff
+	}
+	if options.BreakerThreshold == 0 {
+		options.BreakerThreshold = defaultBreakerThreshold
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = defaultBreakerCooldown
+	}
+	if options.RevocationTTL <= 0 {
+		options.RevocationTTL = defaultRevocationTTL
+	}
+
+	client := options.HTTPClient
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// every request goes to the same host
+		transport.MaxIdleConnsPerHost = 32
+		client = &http.Client{Transport: transport}
+	}
+
+	return &Client{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		options:     options,
+		http:        client,
+		breaker:     newBreaker(options.BreakerThreshold, options.BreakerCooldown),
+		revocations: newRevocations(options.RevocationTTL),
+	}
+}
+
+// Available reports whether calls go out to the service, that is whether the
+// circuit breaker is closed or about to probe.
+func (c *Client) Available() bool {
+	return !c.breaker.open()
+}
+
+// response is the envelope of every reply of the service.
+type response struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// request describes a call to the service. Calls that change state only once
+// however often they are made are idempotent and retried on any failure,
+// others only when the service surely did not get them.
+type request struct {
+	method     string
+	path       string
+	body       any
+	form       url.Values
+	status     int
+	idempotent bool
+}
+
+// do sends req, retrying failures, and decodes the data of the reply into out
+// when given. Endpoints that do not use the envelope, reply with status
+// http.StatusOK and are decoded whole into out.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	if req.status == 0 {
+		req.status = http.StatusAccepted
+	}
+
+	var body []byte
+	var contentType string
+	switch {
+	case req.form != nil:
+		body = []byte(req.form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	case req.body != nil:
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	backoff := c.options.Backoff
+	for attempt := 0; ; attempt++ {
+		ok, probe := c.breaker.allow()
+		if !ok {
+			return &unavailableError{err: ErrCircuitOpen}
+		}
+
+		retry, err := c.attempt(ctx, req, body, contentType, out)
+		if ctx.Err() != nil {
+			c.breaker.release(probe)
+		} else {
+			c.breaker.done(probe, errors.Is(err, ErrUnavailable))
+		}
+		if err == nil || !retry || attempt >= c.options.Retries {
+			return err
+		}
+
+		// jitter keeps clients that failed together from retrying together
+		wait := time.Duration(rand.Int63n(int64(backoff))) + backoff/2
+		select {
+		case <-ctx.Done():
+			return &unavailableError{err: ctx.Err()}
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// attempt sends req once and reports whether a failure is worth retrying.
+func (c *Client) attempt(ctx context.Context, req request, body []byte, contentType string, out any) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+
+	httpRequest, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	if contentType != "" {
+		httpRequest.Header.Set("Content-Type", contentType)
+	}
+	httpRequest.Header.Set("Accept", "application/json")
+	if c.options.ServiceKey != "" {
+		httpRequest.Header.Set("X-Service-Key", c.options.ServiceKey)
+	}
+
+	httpResponse, err := c.http.Do(httpRequest)
+	if err != nil {
+		return req.idempotent || notSent(err), &unavailableError{err: err}
+	}
+	defer func() {
+		// drain the body so the connection goes back to the pool
+		io.Copy(io.Discard, io.LimitReader(httpResponse.Body, 1<<16))
+		httpResponse.Body.Close()
+	}()
+
+	if httpResponse.StatusCode == req.status && req.status == http.StatusOK {
+		if out == nil {
+			return false, nil
+		}
+		if err := json.NewDecoder(httpResponse.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("authclient: decoding reply: %w", err)
+		}
+		return false, nil
+	}
+
+	var envelope response
+	decodeErr := json.NewDecoder(httpResponse.Body).Decode(&envelope)
+
+	if httpResponse.StatusCode != req.status {
+		apiErr := &Error{
+			Status:  httpResponse.StatusCode,
+			Message: envelope.Message,
+			Header:  httpResponse.Header,
+		}
+		return retryStatus(httpResponse.StatusCode, req.idempotent), apiErr
+	}
+
+	if decodeErr != nil {
+		return false, fmt.Errorf("authclient: decoding reply: %w", decodeErr)
+	}
+	if out != nil && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			return false, fmt.Errorf("authclient: decoding reply: %w", err)
+		}
+	}
+
+	return false, nil
+}
+
+// retryStatus reports whether a reply with status is worth retrying. Gateways
+// answer 502 and 504 when the service is down or slow, so a request that is
+// not idempotent may have been handled already.
+func retryStatus(status int, idempotent bool) bool {
+	switch status {
+	case http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+
+	return false
+}
+
+// notSent reports whether err shows the request never reached the service.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,84 @@
+package authclient
+
+import (
+	"sync"
+	"time"
+)
+
+// revocations remembers the sessions ended through the client, so that their
+// access tokens, which services verify by signature alone, are refused at once
+// rather than when they expire. Sessions ended elsewhere are not known here
+// and their access tokens keep working until they expire.
+type revocations struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]time.Time
+	emails   map[string]emailRevocation
+}
+
+// emailRevocation ends every session of an email opened before at, but the
+// session except.
+type emailRevocation struct {
+	at     time.Time
+	except string
+}
+
+func newRevocations(ttl time.Duration) *revocations {
+	return &revocations{
+		ttl:      ttl,
+		sessions: map[string]time.Time{},
+		emails:   map[string]emailRevocation{},
+	}
+}
+
+// session records that the session id was ended.
+func (r *revocations) session(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	r.sessions[id] = time.Now()
+}
+
+// all records that every session of email but except was ended.
+func (r *revocations) all(email string, except string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire()
+	r.emails[email] = emailRevocation{at: time.Now(), except: except}
+}
+
+// revoked reports whether a token of the session id of email, issued at
+// issuedAt, belongs to a session ended since.
+func (r *revocations) revoked(email string, sessionID string, issuedAt time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if at, ok := r.sessions[sessionID]; ok && time.Since(at) < r.ttl {
+		return true
+	}
+
+	revocation, ok := r.emails[email]
+	if !ok || time.Since(revocation.at) >= r.ttl || sessionID == revocation.except {
+		return false
+	}
+
+	// issued times are whole seconds, a token of the same second is refused
+	return !issuedAt.After(revocation.at)
+}
+
+// expire drops the revocations older than the ttl, whose tokens expired.
+func (r *revocations) expire() {
+	for id, at := range r.sessions {
+		if time.Since(at) >= r.ttl {
+			delete(r.sessions, id)
+		}
+	}
+	for email, revocation := range r.emails {
+		if time.Since(revocation.at) >= r.ttl {
+			delete(r.emails, email)
+		}
+	}
+}
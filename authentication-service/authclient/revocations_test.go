@@ -0,0 +1,54 @@
+package authclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevocations(t *testing.T) {
+	r := newRevocations(time.Minute)
+	before := time.Now().Add(-time.Second)
+
+	r.session("s1")
+	r.all("bob@example.com", "s3")
+
+	tests := []struct {
+		name      string
+		email     string
+		sessionID string
+		issuedAt  time.Time
+		want      bool
+	}{
+		{"ended session", "alice@example.com", "s1", before, true},
+		{"other session", "alice@example.com", "s2", before, false},
+		{"all sessions ended", "bob@example.com", "s2", before, true},
+		{"session kept", "bob@example.com", "s3", before, false},
+		{"session opened after", "bob@example.com", "s4", time.Now().Add(time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.revoked(tt.email, tt.sessionID, tt.issuedAt); got != tt.want {
+				t.Errorf("revoked(%q, %q) = %v, want %v", tt.email, tt.sessionID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevocationsExpire(t *testing.T) {
+	r := newRevocations(time.Minute)
+	r.sessions["s1"] = time.Now().Add(-2 * time.Minute)
+	r.emails["bob@example.com"] = emailRevocation{at: time.Now().Add(-2 * time.Minute)}
+
+	if r.revoked("alice@example.com", "s1", time.Now().Add(-time.Hour)) {
+		t.Error("session revoked past the ttl still refused")
+	}
+	if r.revoked("bob@example.com", "s2", time.Now().Add(-time.Hour)) {
+		t.Error("sessions revoked past the ttl still refused")
+	}
+
+	r.session("s2")
+	if _, ok := r.sessions["s1"]; ok {
+		t.Error("expired revocation kept")
+	}
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"authentication-service/data"
+	"authentication-service/jwt"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email string `json:"user"`
 		Token string `json:"token"`
+		// IP is the client user-service checks the token for.
+		IP string `json:"ip"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -22,17 +28,21 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var cachedToken string
-	jsonToken := app.Cache.HGet("userTokens", requestPayload.Email)
+	if !app.limitIP(w, r, app.Limits.AuthenticateIP, app.forwardedIP(r, requestPayload.IP)) {
+		return
+	}
 
-	err = json.Unmarshal([]byte(jsonToken), &cachedToken)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
+	email := data.NormalizeEmail(requestPayload.Email)
+	limit, err := app.Limits.AuthenticateFailures.Peek(email)
+	if err == nil && !limit.Allowed {
+		app.rateLimited(w, limit)
 		return
 	}
 
-	if cachedToken != requestPayload.Token {
+	session, err := app.Sessions.Authenticate(requestPayload.Token)
+	if err != nil || data.NormalizeEmail(session.Email) != email {
 		log.Print("invalid token or token doesnt match")
+		app.Limits.AuthenticateFailures.Allow(email)
 		app.errorJSON(w, errors.New("Request unauthorized"), http.StatusUnauthorized)
 		return
 	}
@@ -40,15 +50,29 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Valid token for user %s", requestPayload.Email),
+		Data: map[string]any{
+			"email":       session.Email,
+			"session_id":  session.ID,
+			"roles":       session.Roles,
+			"permissions": session.Permissions,
+		},
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 
 }
 
+// GenerateToken opens a session for a user whose credentials user-service
+// checked, carrying the roles user-service resolved, and returns its tokens.
 func (app *Config) GenerateToken(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Email string `json:"email"`
+		UserID      int      `json:"user_id"`
+		Email       string   `json:"email"`
+		Device      string   `json:"device"`
+		IP          string   `json:"ip"`
+		UserAgent   string   `json:"user_agent"`
+		Roles       []string `json:"roles"`
+		Permissions []string `json:"permissions"`
 	}
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
@@ -57,28 +81,151 @@ func (app *Config) GenerateToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := "authToken"
-	_, err = app.Cache.HSetNX("userTokens", requestPayload.Email, token, 24*time.Hour)
+	session := &data.Session{
+		UserID:      requestPayload.UserID,
+		Email:       requestPayload.Email,
+		Device:      requestPayload.Device,
+		IP:          requestPayload.IP,
+		UserAgent:   requestPayload.UserAgent,
+		Roles:       requestPayload.Roles,
+		Permissions: requestPayload.Permissions,
+	}
+
+	token, err := app.Sessions.Create(session)
 	if err != nil {
-		log.Printf("error while setting redis key, %s", err)
+		log.Printf("error while creating session, %s", err)
+		app.errorJSON(w, errors.New("unable to create session"), http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, err := app.Sessions.IssueRefreshToken(session)
+	if err != nil {
+		log.Printf("error while creating refresh token, %s", err)
+		app.errorJSON(w, errors.New("unable to create session"), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := app.issueAccessToken(session)
+	if err != nil {
+		log.Printf("error while signing access token, %s", err)
+		app.errorJSON(w, errors.New("unable to create access token"), http.StatusInternalServerError)
+		return
 	}
 
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Token generated for %s", requestPayload.Email),
-		Data: map[string]string{
-			"email": requestPayload.Email,
-			"token": token,
+		Data: map[string]any{
+			"email":              requestPayload.Email,
+			"token":              token,
+			"session_id":         session.ID,
+			"access_token":       accessToken,
+			"token_type":         "Bearer",
+			"expires_in":         int(app.Settings.Tokens.AccessTokenTTL.Seconds()),
+			"refresh_token":      refreshToken,
+			"refresh_expires_in": int(app.Settings.Tokens.SessionTTL.Seconds()),
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, &payload)
+
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Presenting a refresh token twice revokes its session.
+func (app *Config) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	session, refreshToken, err := app.Sessions.Refresh(requestPayload.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidToken), errors.Is(err, data.ErrRefreshTokenReused):
+			app.errorJSON(w, err, http.StatusUnauthorized)
+		default:
+			log.Printf("error while refreshing token, %s", err)
+			app.errorJSON(w, errors.New("unable to refresh token"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	accessToken, err := app.issueAccessToken(session)
+	if err != nil {
+		log.Printf("error while signing access token, %s", err)
+		app.errorJSON(w, errors.New("unable to create access token"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Token refreshed for %s", session.Email),
+		Data: map[string]any{
+			"email":              session.Email,
+			"session_id":         session.ID,
+			"access_token":       accessToken,
+			"token_type":         "Bearer",
+			"expires_in":         int(app.Settings.Tokens.AccessTokenTTL.Seconds()),
+			"refresh_token":      refreshToken,
+			"refresh_expires_in": int(app.Settings.Tokens.SessionTTL.Seconds()),
 		},
 	}
 
 	app.writeJSON(w, http.StatusAccepted, &payload)
+}
+
+// JWKS publishes the public keys access tokens are signed with.
+func (app *Config) JWKS(w http.ResponseWriter, r *http.Request) {
+	set := jwt.JWKS{Keys: []jwt.JWK{}}
+	for _, key := range app.Keys.Published() {
+		set.Keys = append(set.Keys, jwt.NewJWK(key.ID, key.PublicKey()))
+	}
 
+	headers := http.Header{}
+	headers.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(keyReloadInterval.Seconds())))
+
+	app.writeJSON(w, http.StatusOK, set, headers)
+}
+
+// issueAccessToken signs a short lived access token for session.
+func (app *Config) issueAccessToken(session *data.Session) (string, error) {
+	return app.signAccessToken(&jwt.Claims{
+		Subject:     strconv.Itoa(session.UserID),
+		Email:       session.Email,
+		Scope:       firstPartyScope,
+		SessionID:   session.ID,
+		Roles:       session.Roles,
+		Permissions: session.Permissions,
+	})
+}
+
+// signAccessToken stamps claims with the issuer and validity period and signs
+// them with the current key.
+func (app *Config) signAccessToken(claims *jwt.Claims) (string, error) {
+	key, err := app.Keys.Current()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.Issuer = app.Settings.Tokens.Issuer
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(app.Settings.Tokens.AccessTokenTTL).Unix()
+
+	return jwt.Sign(claims, key.ID, key.PrivateKey())
 }
 
+// RevokeSession ends the session the given token belongs to.
 func (app *Config) RevokeSession(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email string `json:"email"`
+		Token string `json:"token"`
 	}
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
@@ -87,7 +234,17 @@ func (app *Config) RevokeSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.Cache.HDel("userTokens", requestPayload.Email)
+	session, err := app.Sessions.Find(requestPayload.Token)
+	if err != nil || data.NormalizeEmail(session.Email) != data.NormalizeEmail(requestPayload.Email) {
+		app.errorJSON(w, errors.New("Request unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	err = app.Sessions.Revoke(session.Email, session.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
 
 	payload := jsonResponse{
 		Error:   false,
@@ -98,3 +255,250 @@ func (app *Config) RevokeSession(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, &payload)
 
 }
+
+// ListSessions returns every live session of a user. When a session id is
+// given, that session is flagged as current.
+func (app *Config) ListSessions(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email     string `json:"email"`
+		SessionID string `json:"session_id"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := app.Sessions.List(requestPayload.Email)
+	if err != nil {
+		log.Printf("error while listing sessions, %s", err)
+		app.errorJSON(w, errors.New("unable to list sessions"), http.StatusInternalServerError)
+		return
+	}
+
+	type sessionView struct {
+		*data.Session
+		Current bool `json:"current"`
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		current := session.ID == requestPayload.SessionID
+		session.TokenHash = ""
+		session.RefreshHash = ""
+		views = append(views, sessionView{Session: session, Current: current})
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d sessions for %s", len(views), requestPayload.Email),
+		Data:    views,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, &payload)
+}
+
+// GetSession returns a single live session of a user, so user-service can
+// refuse the access tokens of sessions ended elsewhere before they expire.
+func (app *Config) GetSession(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	session, err := app.Sessions.Get(requestPayload.Email, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+	session.TokenHash = ""
+	session.RefreshHash = ""
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("session %s of %s", id, requestPayload.Email),
+		Data:    session,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, &payload)
+}
+
+// RevokeSessionByID ends a single session of a user.
+func (app *Config) RevokeSessionByID(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err = app.Sessions.Revoke(requestPayload.Email, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("session %s revoked for %s", id, requestPayload.Email),
+		Data:    map[string]string{},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, &payload)
+}
+
+// RevokeAllSessions ends every session of a user, except the one with the
+// given session id when one is given.
+func (app *Config) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email  string `json:"email"`
+		Except string `json:"except"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := app.Sessions.RevokeAll(requestPayload.Email, requestPayload.Except)
+	if err != nil {
+		log.Printf("error while revoking sessions, %s", err)
+		app.errorJSON(w, errors.New("unable to revoke sessions"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d sessions revoked for %s", revoked, requestPayload.Email),
+		Data: map[string]int{
+			"revoked": revoked,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, &payload)
+}
+
+// introspectionResponse is the token introspection reply of RFC 7662. An
+// inactive token is described by the active member alone.
+type introspectionResponse struct {
+	Active      bool     `json:"active"`
+	Subject     string   `json:"sub,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	SessionID   string   `json:"session_id,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	TokenType   string   `json:"token_type,omitempty"`
+}
+
+// Introspect describes an access token or a session token following RFC 7662.
+// The token is sent form encoded as the token parameter. Callers have to
+// authenticate, as section 2.1 requires: user-service with its service key,
+// confidential clients with their credentials as at the token endpoint.
+// Anyone else is told every token is inactive, so tokens cannot be probed.
+func (app *Config) Introspect(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	err := r.ParseForm()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		app.errorJSON(w, errors.New("token is required"), http.StatusBadRequest)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	if !app.introspectionAllowed(r) {
+		app.writeJSON(w, http.StatusOK, introspectionResponse{Active: false}, headers)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, app.introspect(token), headers)
+}
+
+// introspectionAllowed reports whether the caller of Introspect authenticated.
+func (app *Config) introspectionAllowed(r *http.Request) bool {
+	if app.fromService(r) {
+		return true
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" {
+		return false
+	}
+	client, err := app.OAuth.AuthenticateClient(clientID, clientSecret)
+	if err != nil {
+		log.Printf("introspection refused to client %s, %s", clientID, err)
+		return false
+	}
+
+	// anyone can register a public client, it proves nothing
+	return client.Confidential()
+}
+
+// introspect resolves token as a signed access token first, then as an opaque
+// session token. Access tokens of revoked sessions are reported inactive.
+func (app *Config) introspect(token string) introspectionResponse {
+	if claims, err := jwt.Parse(token, app.Keys.PublicKey); err == nil {
+		if claims.SessionID != "" {
+			if _, err := app.Sessions.Get(claims.Email, claims.SessionID); err != nil {
+				return introspectionResponse{Active: false}
+			}
+		}
+
+		return introspectionResponse{
+			Active:      true,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			Scope:       claims.Scope,
+			ExpiresAt:   claims.ExpiresAt,
+			IssuedAt:    claims.IssuedAt,
+			SessionID:   claims.SessionID,
+			ClientID:    claims.ClientID,
+			Roles:       claims.Roles,
+			Permissions: claims.Permissions,
+			Issuer:      claims.Issuer,
+			TokenType:   "Bearer",
+		}
+	}
+
+	session, err := app.Sessions.Find(token)
+	if err != nil {
+		return introspectionResponse{Active: false}
+	}
+
+	return introspectionResponse{
+		Active:      true,
+		Subject:     strconv.Itoa(session.UserID),
+		Email:       session.Email,
+		Scope:       firstPartyScope,
+		ExpiresAt:   session.CreatedAt.Add(app.Settings.Tokens.SessionTokenTTL).Unix(),
+		IssuedAt:    session.CreatedAt.Unix(),
+		SessionID:   session.ID,
+		Roles:       session.Roles,
+		Permissions: session.Permissions,
+		Issuer:      app.Settings.Tokens.Issuer,
+		TokenType:   "session",
+	}
+}
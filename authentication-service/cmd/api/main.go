@@ -1,39 +1,118 @@
 package main
 
 import (
+	"authentication-service/config"
 	"authentication-service/data"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 const (
-	redisPort = "6379"
-	webPort   = "80"
+	keyReloadInterval = time.Minute
+
+	// firstPartyScope is granted to tokens issued on login through user-service.
+	firstPartyScope = "tweet.read tweet.write users.read users.write"
 )
 
 type Config struct {
-	Cache *data.Cache
+	Settings *config.Config
+	Cache    data.Cache
+	Sessions *data.SessionStore
+	Keys     *data.KeyStore
+	OAuth    *data.OAuthStore
+	Limits   rateLimiters
+	Lockouts *data.LockoutStore
 }
 
 func main() {
-	log.Println("Starting authentication service ...")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
 
-	// connect to redis
-	cache := data.NewCache(fmt.Sprintf("redis:%s", redisPort))
-	err := cache.Connect()
+	settings, err := config.Load(*configFile)
 	if err != nil {
-		log.Fatalf("Error while connecting to redis, %s", err)
+		log.Fatalf("Error while loading configuration, %s", err)
+	}
+
+	if *printConfig {
+		out, err := settings.Redacted().YAML()
+		if err != nil {
+			log.Fatalf("Error while printing configuration, %s", err)
+		}
+		fmt.Print(out)
+		return
+	}
+
+	log.Println("Starting authentication service ...")
+
+	// connect to redis, or keep everything in memory for local runs
+	var cache data.Cache
+	if settings.Cache.Driver == "memory" {
+		log.Println("Using in-memory cache, state is lost on restart")
+		cache = data.NewMemoryCache()
+	} else {
+		redis := settings.Cache.Redis
+		redisCache := data.NewRedisCache(data.RedisOptions{
+			Mode:             redis.Mode,
+			Addrs:            redis.Nodes(),
+			MasterName:       redis.MasterName,
+			Password:         redis.Password,
+			SentinelPassword: redis.SentinelPassword,
+			DB:               redis.DB,
+		})
+		err = redisCache.Connect()
+		if err != nil {
+			log.Fatalf("Error while connecting to redis, %s", err)
+		}
+		cache = redisCache
+	}
+
+	tokens := settings.Tokens
+
+	// load the access token signing keys, another replica may be generating them
+	keys := data.NewKeyStore(cache, tokens.KeyRotationPeriod, tokens.AccessTokenTTL)
+	for attempt := 1; ; attempt++ {
+		err = keys.Load()
+		if err == nil {
+			break
+		}
+		if attempt >= 5 {
+			log.Fatalf("Error while loading signing keys, %s", err)
+		}
+		time.Sleep(time.Second)
 	}
+	go keys.Run(keyReloadInterval, nil)
+
+	limits := settings.RateLimit
+	lockout := limits.Lockout
 
 	// Set up config
 	app := Config{
-		Cache: cache,
+		Settings: settings,
+		Cache:    cache,
+		Sessions: data.NewSessionStore(cache, tokens.SessionTTL, tokens.SessionTokenTTL),
+		Keys:     keys,
+		OAuth:    data.NewOAuthStore(cache, tokens.AuthCodeTTL),
+		Limits: rateLimiters{
+			LoginIP:              data.NewRateLimiter(cache, "loginIP", limits.LoginPerIP.Limit, limits.LoginPerIP.Window),
+			LoginEmail:           data.NewRateLimiter(cache, "loginEmail", limits.LoginPerEmail.Limit, limits.LoginPerEmail.Window),
+			AuthenticateIP:       data.NewRateLimiter(cache, "authenticateIP", limits.AuthenticatePerIP.Limit, limits.AuthenticatePerIP.Window),
+			AuthenticateFailures: data.NewRateLimiter(cache, "authenticateFailures", limits.AuthenticateFailuresPerEmail.Limit, limits.AuthenticateFailuresPerEmail.Window),
+		},
+		Lockouts: data.NewLockoutStore(cache, lockout.MaxFailures, lockout.FailureWindow, lockout.Duration, lockout.MaxDuration),
+	}
+
+	if settings.AdminAPIKey == "" {
+		log.Println("No admin API key set, admin endpoints are disabled")
 	}
 
 	// define http server
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", webPort),
+		Addr:    fmt.Sprintf(":%s", settings.WebPort),
 		Handler: app.routes(),
 	}
 
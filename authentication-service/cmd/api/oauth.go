@@ -0,0 +1,405 @@
+package main
+
+import (
+	"authentication-service/data"
+	"authentication-service/jwt"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// oauthErrorResponse is the error reply of RFC 6749 section 5.2.
+type oauthErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// authorizeRequest holds the parameters of an authorization request.
+type authorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// RegisterClient registers a third party application owned by the calling
+// user. The client secret of confidential clients is only shown once.
+func (app *Config) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.bearerUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		GrantTypes   []string `json:"grant_types"`
+		Confidential bool     `json:"confidential"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Name == "" {
+		app.errorJSON(w, errors.New("name is required"), http.StatusBadRequest)
+		return
+	}
+
+	if len(requestPayload.GrantTypes) == 0 {
+		requestPayload.GrantTypes = []string{data.GrantAuthorizationCode}
+	}
+
+	for _, grantType := range requestPayload.GrantTypes {
+		switch grantType {
+		case data.GrantAuthorizationCode:
+			if len(requestPayload.RedirectURIs) == 0 {
+				app.errorJSON(w, errors.New("redirect_uris are required for the authorization_code grant"), http.StatusBadRequest)
+				return
+			}
+		case data.GrantClientCredentials:
+			if !requestPayload.Confidential {
+				app.errorJSON(w, errors.New("the client_credentials grant requires a confidential client"), http.StatusBadRequest)
+				return
+			}
+		default:
+			app.errorJSON(w, fmt.Errorf("unsupported grant type %s", grantType), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, uri := range requestPayload.RedirectURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			app.errorJSON(w, fmt.Errorf("invalid redirect uri %s", uri), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, scope := range requestPayload.Scopes {
+		if !knownScope(scope) {
+			app.errorJSON(w, fmt.Errorf("unknown scope %s", scope), http.StatusBadRequest)
+			return
+		}
+	}
+
+	client := &data.Client{
+		Name:         requestPayload.Name,
+		OwnerEmail:   owner.Email,
+		RedirectURIs: requestPayload.RedirectURIs,
+		Scopes:       requestPayload.Scopes,
+		GrantTypes:   requestPayload.GrantTypes,
+	}
+
+	secret, err := app.OAuth.RegisterClient(client, requestPayload.Confidential)
+	if err != nil {
+		log.Printf("error while registering client, %s", err)
+		app.errorJSON(w, errors.New("unable to register client"), http.StatusInternalServerError)
+		return
+	}
+
+	client.SecretHash = ""
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Client %s registered", client.Name),
+		Data: map[string]any{
+			"client":        client,
+			"client_secret": secret,
+		},
+	}
+
+	app.writeJSON(w, http.StatusCreated, &payload)
+}
+
+// AuthorizeRequest validates an authorization request and describes what the
+// user is asked to consent to.
+func (app *Config) AuthorizeRequest(w http.ResponseWriter, r *http.Request) {
+	if _, err := app.bearerUser(r); err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	request := authorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	client, err := app.validateAuthorizeRequest(&request)
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%s is requesting access to your account", client.Name),
+		Data: map[string]any{
+			"client_id":    client.ID,
+			"client_name":  client.Name,
+			"scopes":       strings.Fields(request.Scope),
+			"redirect_uri": request.RedirectURI,
+			"state":        request.State,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, &payload)
+}
+
+// Authorize records the decision of the user on an authorization request and
+// returns where to send the user agent: back to the client with an
+// authorization code, or with an access_denied error.
+func (app *Config) Authorize(w http.ResponseWriter, r *http.Request) {
+	user, err := app.bearerUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var request authorizeRequest
+	err = app.readJSON(w, r, &request)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	client, err := app.validateAuthorizeRequest(&request)
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	params := url.Values{}
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+
+	if !request.Approve {
+		params.Set("error", "access_denied")
+		app.writeRedirect(w, request.RedirectURI, params)
+		return
+	}
+
+	userID, _ := strconv.Atoi(user.Subject)
+	code, err := app.OAuth.CreateCode(&data.AuthorizationCode{
+		ClientID:            client.ID,
+		RedirectURI:         request.RedirectURI,
+		Scope:               request.Scope,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		UserID:              userID,
+		Email:               user.Email,
+	})
+	if err != nil {
+		log.Printf("error while creating authorization code, %s", err)
+		params.Set("error", "server_error")
+		app.writeRedirect(w, request.RedirectURI, params)
+		return
+	}
+
+	params.Set("code", code)
+	app.writeRedirect(w, request.RedirectURI, params)
+}
+
+// OAuthToken is the token endpoint of RFC 6749. It serves the
+// authorization_code grant, with PKCE required, and the client_credentials
+// grant. Parameters are sent form encoded, client credentials either with
+// HTTP basic authentication or as client_id and client_secret parameters.
+func (app *Config) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	if err := r.ParseForm(); err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, err := app.OAuth.AuthenticateClient(clientCredentials(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		app.oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if !client.AllowsGrant(grantType) {
+		app.oauthError(w, http.StatusBadRequest, "unauthorized_client", fmt.Sprintf("grant type %q is not allowed for this client", grantType))
+		return
+	}
+
+	var claims *jwt.Claims
+	switch grantType {
+	case data.GrantAuthorizationCode:
+		grant, err := app.OAuth.RedeemCode(r.PostForm.Get("code"))
+		if err != nil {
+			app.oauthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+
+		if grant.ClientID != client.ID || grant.RedirectURI != r.PostForm.Get("redirect_uri") {
+			app.oauthError(w, http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client or redirect uri")
+			return
+		}
+
+		if !grant.VerifyChallenge(r.PostForm.Get("code_verifier")) {
+			app.oauthError(w, http.StatusBadRequest, "invalid_grant", "code verifier does not match the code challenge")
+			return
+		}
+
+		claims = &jwt.Claims{
+			Subject:  strconv.Itoa(grant.UserID),
+			Email:    grant.Email,
+			Scope:    grant.Scope,
+			ClientID: client.ID,
+		}
+
+	case data.GrantClientCredentials:
+		scope := r.PostForm.Get("scope")
+		if scope == "" {
+			scope = strings.Join(client.Scopes, " ")
+		}
+		if !client.AllowsScope(scope) {
+			app.oauthError(w, http.StatusBadRequest, "invalid_scope", "scope was not registered for this client")
+			return
+		}
+
+		claims = &jwt.Claims{
+			Subject:  client.ID,
+			Scope:    scope,
+			ClientID: client.ID,
+		}
+
+	default:
+		app.oauthError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("grant type %q is not supported", grantType))
+		return
+	}
+
+	accessToken, err := app.signAccessToken(claims)
+	if err != nil {
+		log.Printf("error while signing access token, %s", err)
+		app.oauthError(w, http.StatusInternalServerError, "server_error", "unable to create access token")
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(app.Settings.Tokens.AccessTokenTTL.Seconds()),
+		"scope":        claims.Scope,
+	}, headers)
+}
+
+// validateAuthorizeRequest checks an authorization request against the
+// registered client.
+func (app *Config) validateAuthorizeRequest(request *authorizeRequest) (*data.Client, error) {
+	client, err := app.OAuth.GetClient(request.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.AllowsRedirect(request.RedirectURI) {
+		return nil, errors.New("redirect uri is not registered for this client")
+	}
+	if request.ResponseType != "code" {
+		return nil, errors.New("response_type must be code")
+	}
+	if !client.AllowsGrant(data.GrantAuthorizationCode) {
+		return nil, errors.New("client may not use the authorization_code grant")
+	}
+	if request.Scope == "" || !client.AllowsScope(request.Scope) {
+		return nil, errors.New("scope is missing or was not registered for this client")
+	}
+	if request.CodeChallenge == "" || request.CodeChallengeMethod != "S256" {
+		return nil, errors.New("a PKCE code_challenge with code_challenge_method S256 is required")
+	}
+
+	return client, nil
+}
+
+// bearerUser returns the claims of the first party access token sent in the
+// Authorization header. Tokens issued to third party clients are refused.
+func (app *Config) bearerUser(r *http.Request) (*jwt.Claims, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errors.New("bearer token required")
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	claims, err := jwt.Parse(token, app.Keys.PublicKey)
+	if err != nil || claims.ClientID != "" || claims.SessionID == "" {
+		return nil, errors.New("Request unauthorized")
+	}
+
+	if _, err := app.Sessions.Get(claims.Email, claims.SessionID); err != nil {
+		return nil, errors.New("Request unauthorized")
+	}
+
+	return claims, nil
+}
+
+// writeRedirect tells the caller to send the user agent to uri with params
+// added to its query.
+func (app *Config) writeRedirect(w http.ResponseWriter, uri string, params url.Values) {
+	target, _ := url.Parse(uri)
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	target.RawQuery = query.Encode()
+
+	payload := jsonResponse{
+		Error:   params.Get("error") != "",
+		Message: "redirect the user agent to redirect_to",
+		Data: map[string]string{
+			"redirect_to": target.String(),
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, &payload)
+}
+
+func (app *Config) oauthError(w http.ResponseWriter, status int, code string, description string) {
+	app.writeJSON(w, status, oauthErrorResponse{Error: code, Description: description})
+}
+
+func knownScope(scope string) bool {
+	for _, s := range data.KnownScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientCredentials returns the client id and secret of r, sent with HTTP
+// basic authentication or else as the client_id and client_secret form
+// parameters. The form has to be parsed.
+func clientCredentials(r *http.Request) (string, string) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	return clientID, clientSecret
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"authentication-service/data"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimiters bound how often clients may try credentials.
+type rateLimiters struct {
+	LoginIP              *data.RateLimiter
+	LoginEmail           *data.RateLimiter
+	AuthenticateIP       *data.RateLimiter
+	AuthenticateFailures *data.RateLimiter
+}
+
+// loginAttempt identifies a password login, reported by user-service around
+// its own password check.
+type loginAttempt struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+// LoginAttempt counts a password login against the limits of its client IP
+// and email and refuses it when either is exceeded or the account is locked
+// out. user-service calls it before checking the password.
+func (app *Config) LoginAttempt(w http.ResponseWriter, r *http.Request) {
+	var requestPayload loginAttempt
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	email := data.NormalizeEmail(requestPayload.Email)
+
+	locked, err := app.Lockouts.Locked(email)
+	if err != nil {
+		log.Printf("error while checking lockout, %s", err)
+		app.errorJSON(w, errors.New("unable to check login attempt"), http.StatusInternalServerError)
+		return
+	}
+	if locked > 0 {
+		app.lockedOut(w, locked)
+		return
+	}
+
+	byIP, err := app.Limits.LoginIP.Allow(requestPayload.IP)
+	if err != nil {
+		log.Printf("error while rate limiting login, %s", err)
+		app.errorJSON(w, errors.New("unable to check login attempt"), http.StatusInternalServerError)
+		return
+	}
+	if !byIP.Allowed {
+		app.rateLimited(w, byIP)
+		return
+	}
+
+	byEmail, err := app.Limits.LoginEmail.Allow(email)
+	if err != nil {
+		log.Printf("error while rate limiting login, %s", err)
+		app.errorJSON(w, errors.New("unable to check login attempt"), http.StatusInternalServerError)
+		return
+	}
+	if !byEmail.Allowed {
+		app.rateLimited(w, byEmail)
+		return
+	}
+
+	limit := byIP
+	if byEmail.Remaining < byIP.Remaining {
+		limit = byEmail
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "login attempt allowed",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload, rateLimitHeaders(limit))
+}
+
+// LoginFailed records a failed password check, locking the account out once
+// it failed too often.
+func (app *Config) LoginFailed(w http.ResponseWriter, r *http.Request) {
+	var requestPayload loginAttempt
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	locked, err := app.Lockouts.Fail(requestPayload.Email)
+	if err != nil {
+		log.Printf("error while recording failed login, %s", err)
+		app.errorJSON(w, errors.New("unable to record failed login"), http.StatusInternalServerError)
+		return
+	}
+	if locked > 0 {
+		log.Printf("[User=%s] locked out for %s after failed logins from %s", requestPayload.Email, locked, requestPayload.IP)
+		app.lockedOut(w, locked)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "failed login recorded",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// LoginSucceeded forgets the failed logins of an account once its password
+// matched.
+func (app *Config) LoginSucceeded(w http.ResponseWriter, r *http.Request) {
+	var requestPayload loginAttempt
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	app.Lockouts.Succeed(requestPayload.Email)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "successful login recorded",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ClearLockout lifts the lockout of an account and resets the login limits of
+// its email, and of an IP address when one is given.
+func (app *Config) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	var requestPayload loginAttempt
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Printf("error while reading response %s", err)
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Email == "" && requestPayload.IP == "" {
+		app.errorJSON(w, errors.New("email or ip is required"), http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Email != "" {
+		email := data.NormalizeEmail(requestPayload.Email)
+		app.Lockouts.Clear(email)
+		app.Limits.LoginEmail.Reset(email)
+		app.Limits.AuthenticateFailures.Reset(email)
+	}
+	if requestPayload.IP != "" {
+		app.Limits.LoginIP.Reset(requestPayload.IP)
+		app.Limits.AuthenticateIP.Reset(requestPayload.IP)
+	}
+
+	log.Printf("lockout cleared for email %q ip %q", requestPayload.Email, requestPayload.IP)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "lockout cleared",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// limitIP counts a request of ip against limiter and reports whether it may
+// go on, answering it otherwise.
+func (app *Config) limitIP(w http.ResponseWriter, r *http.Request, limiter *data.RateLimiter, ip string) bool {
+	limit, err := limiter.Allow(ip)
+	if err != nil {
+		// an unreachable cache should not take the endpoint down with it
+		log.Printf("error while rate limiting %s, %s", r.URL.Path, err)
+		return true
+	}
+	if !limit.Allowed {
+		app.rateLimited(w, limit)
+		return false
+	}
+
+	for key, value := range rateLimitHeaders(limit) {
+		w.Header()[key] = value
+	}
+
+	return true
+}
+
+func (app *Config) rateLimited(w http.ResponseWriter, limit *data.RateLimit) {
+	headers := rateLimitHeaders(limit)
+	headers.Set("Retry-After", seconds(limit.RetryAfter))
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	app.errorJSON(w, errors.New("too many attempts, try again later"), http.StatusTooManyRequests)
+}
+
+func (app *Config) lockedOut(w http.ResponseWriter, locked time.Duration) {
+	w.Header().Set("Retry-After", seconds(locked))
+	app.errorJSON(w, fmt.Errorf("account locked after too many failed logins, try again in %ss", seconds(locked)), http.StatusTooManyRequests)
+}
+
+func rateLimitHeaders(limit *data.RateLimit) http.Header {
+	headers := http.Header{}
+	headers.Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+	headers.Set("X-RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+	headers.Set("X-RateLimit-Reset", seconds(limit.Reset))
+
+	return headers
+}
+
+// seconds renders d as whole seconds, rounded up so clients never retry early.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// forwardedIP returns ip, the address of the client user-service forwards a
+// call for, when r comes from user-service, and the address r came from
+// otherwise, so that other callers cannot dodge the limits with made up ones.
+func (app *Config) forwardedIP(r *http.Request, ip string) string {
+	if ip != "" && app.fromService(r) {
+		return ip
+	}
+
+	return clientIP(r)
+}
+
+// clientIP returns the address of the client that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
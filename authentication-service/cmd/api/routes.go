@@ -1,18 +1,24 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"net/http"
 )
 
+// permissionLockoutsClear lets an admin or moderator lift a login lockout.
+const permissionLockoutsClear = "lockouts:clear"
+
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// specify who is allowed to connect
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedOrigins:   app.Settings.CORS.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"link"},
@@ -22,9 +28,104 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
-	mux.Post("/authenticate", app.Authenticate)
-	mux.Get("/token", app.GenerateToken)
+	mux.Get("/.well-known/jwks.json", app.JWKS)
+
+	mux.Post("/introspect", app.Introspect)
+	mux.Post("/token/refresh", app.RefreshToken)
 	mux.Delete("/revoke", app.RevokeSession)
 
+	// OAuth2 authorization server for third party clients
+	mux.Post("/clients", app.RegisterClient)
+	mux.Get("/authorize", app.AuthorizeRequest)
+	mux.Post("/authorize", app.Authorize)
+	mux.Post("/token", app.OAuthToken)
+
+	mux.With(app.requirePermission(permissionLockoutsClear)).Delete("/admin/lockouts", app.ClearLockout)
+
+	// user-service checks the credentials and roles of its users, so only it
+	// may open sessions for them
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.requireServiceKey)
+
+		// failed checks count against the email sent, which only a trusted
+		// caller may pick, or anyone could lock a victim out
+		mux.Post("/authenticate", app.Authenticate)
+		mux.Post("/sessions", app.GenerateToken)
+		mux.Get("/sessions", app.ListSessions)
+		mux.Get("/sessions/{id}", app.GetSession)
+		mux.Delete("/sessions", app.RevokeAllSessions)
+		mux.Delete("/sessions/{id}", app.RevokeSessionByID)
+
+		// brute-force protection of the password logins of user-service
+		mux.Post("/login/attempt", app.LoginAttempt)
+		mux.Post("/login/failed", app.LoginFailed)
+		mux.Post("/login/succeeded", app.LoginSucceeded)
+	})
+
 	return mux
 }
+
+// requirePermission only lets through users whose first party access token,
+// sent as a bearer token, carries permission. Operators may use the admin API
+// key instead, sent in the X-Admin-Key header.
+func (app *Config) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Admin-Key") != "" {
+				app.requireAdminKey(next).ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := app.bearerUser(r)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.HasPermission(permission) {
+				app.errorJSON(w, fmt.Errorf("permission %s required", permission), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireAdminKey only lets through requests carrying the admin API key in
+// the X-Admin-Key header.
+func (app *Config) requireAdminKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := app.Settings.AdminAPIKey
+		if key == "" {
+			app.errorJSON(w, errors.New("admin endpoints are disabled"), http.StatusForbidden)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(key)) != 1 {
+			app.errorJSON(w, errors.New("Request unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireServiceKey only lets through requests carrying the service key in
+// the X-Service-Key header, that is calls from user-service.
+func (app *Config) requireServiceKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.fromService(r) {
+			app.errorJSON(w, errors.New("Request unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromService reports whether r carries the service key, that is comes from
+// user-service.
+func (app *Config) fromService(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Service-Key")), []byte(app.Settings.ServiceKey)) == 1
+}
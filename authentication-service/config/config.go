@@ -0,0 +1,477 @@
+// Package config loads the settings of the authentication service. Settings
+// come from built in defaults, then an optional YAML file, then environment
+// variables, each overriding the previous one. Secrets can be read from files
+// named by the matching _FILE variable, e.g. REDIS_PASSWORD_FILE.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[redacted]"
+
+type Config struct {
+	WebPort   string          `yaml:"web_port"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Tokens    TokenConfig     `yaml:"tokens"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	CORS      CORSConfig      `yaml:"cors"`
+	// AdminAPIKey guards the admin endpoints, which are disabled when it is empty.
+	AdminAPIKey     string `yaml:"admin_api_key"`
+	AdminAPIKeyFile string `yaml:"admin_api_key_file"`
+	// ServiceKey authenticates user-service, sent in the X-Service-Key header,
+	// on the endpoints that open, list and end sessions and count logins.
+	ServiceKey     string `yaml:"service_key"`
+	ServiceKeyFile string `yaml:"service_key_file"`
+}
+
+type CacheConfig struct {
+	// Driver is redis, or memory to run without redis.
+	Driver string      `yaml:"driver"`
+	Redis  RedisConfig `yaml:"redis"`
+}
+
+type RedisConfig struct {
+	// Mode is standalone, sentinel or cluster.
+	Mode string `yaml:"mode"`
+	// Addr is the server of a standalone redis.
+	Addr string `yaml:"addr"`
+	// Addrs are the sentinels in sentinel mode and the seed nodes in cluster mode.
+	Addrs                []string `yaml:"addrs"`
+	MasterName           string   `yaml:"master_name"`
+	Password             string   `yaml:"password"`
+	PasswordFile         string   `yaml:"password_file"`
+	SentinelPassword     string   `yaml:"sentinel_password"`
+	SentinelPasswordFile string   `yaml:"sentinel_password_file"`
+	DB                   int      `yaml:"db"`
+}
+
+// Nodes returns the addresses to dial for the configured mode.
+func (r RedisConfig) Nodes() []string {
+	if r.Mode == "standalone" {
+		return []string{r.Addr}
+	}
+
+	return r.Addrs
+}
+
+type TokenConfig struct {
+	Issuer string `yaml:"issuer"`
+	// SessionTTL is how long a session lives without its refresh token being used.
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	// SessionTokenTTL is how long the opaque session token handed out on login lives.
+	SessionTokenTTL   time.Duration `yaml:"session_token_ttl"`
+	AccessTokenTTL    time.Duration `yaml:"access_token_ttl"`
+	KeyRotationPeriod time.Duration `yaml:"key_rotation_period"`
+	AuthCodeTTL       time.Duration `yaml:"auth_code_ttl"`
+}
+
+type RateLimitConfig struct {
+	LoginPerIP    Rate `yaml:"login_per_ip"`
+	LoginPerEmail Rate `yaml:"login_per_email"`
+	// AuthenticatePerIP limits every token check, AuthenticateFailuresPerEmail
+	// only the failed ones.
+	AuthenticatePerIP            Rate          `yaml:"authenticate_per_ip"`
+	AuthenticateFailuresPerEmail Rate          `yaml:"authenticate_failures_per_email"`
+	Lockout                      LockoutConfig `yaml:"lockout"`
+}
+
+type LockoutConfig struct {
+	// MaxFailures failed logins within FailureWindow lock the account out.
+	MaxFailures   int           `yaml:"max_failures"`
+	FailureWindow time.Duration `yaml:"failure_window"`
+	// Duration of the first lockout, doubled on every further one up to MaxDuration.
+	Duration    time.Duration `yaml:"duration"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+type CORSConfig struct {
+	// AllowedOrigins may call the service from a browser with cookies, each
+	// written as scheme://host[:port].
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// Default returns the settings used when nothing overrides them, matching
+// the docker-compose setup.
+func Default() *Config {
+	return &Config{
+		WebPort: "80",
+		Cache: CacheConfig{
+			Driver: "redis",
+			Redis: RedisConfig{
+				Mode: "standalone",
+				Addr: "redis:6379",
+			},
+		},
+		Tokens: TokenConfig{
+			Issuer:            "authentication-service",
+			SessionTTL:        30 * 24 * time.Hour,
+			SessionTokenTTL:   24 * time.Hour,
+			AccessTokenTTL:    15 * time.Minute,
+			KeyRotationPeriod: 24 * time.Hour,
+			AuthCodeTTL:       time.Minute,
+		},
+		RateLimit: RateLimitConfig{
+			LoginPerIP:                   Rate{Limit: 20, Window: time.Minute},
+			LoginPerEmail:                Rate{Limit: 10, Window: 15 * time.Minute},
+			AuthenticatePerIP:            Rate{Limit: 600, Window: time.Minute},
+			AuthenticateFailuresPerEmail: Rate{Limit: 10, Window: 15 * time.Minute},
+			Lockout: LockoutConfig{
+				MaxFailures:   5,
+				FailureWindow: 15 * time.Minute,
+				Duration:      time.Minute,
+				MaxDuration:   time.Hour,
+			},
+		},
+	}
+}
+
+// Load builds the settings from the defaults, the YAML file at path when path
+// is not empty, and the environment, then validates them.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.readEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.readSecrets(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.WebPort == "" {
+		problems = append(problems, "web_port is required")
+	}
+	if c.ServiceKey == "" {
+		problems = append(problems, "service_key is required")
+	}
+
+	switch c.Cache.Driver {
+	case "memory":
+	case "redis":
+		problems = append(problems, c.Cache.Redis.validate()...)
+	default:
+		problems = append(problems, fmt.Sprintf("cache.driver must be redis or memory, got %q", c.Cache.Driver))
+	}
+
+	t := c.Tokens
+	if t.Issuer == "" {
+		problems = append(problems, "tokens.issuer is required")
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"tokens.session_ttl", t.SessionTTL},
+		{"tokens.session_token_ttl", t.SessionTokenTTL},
+		{"tokens.access_token_ttl", t.AccessTokenTTL},
+		{"tokens.key_rotation_period", t.KeyRotationPeriod},
+		{"tokens.auth_code_ttl", t.AuthCodeTTL},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			problems = append(problems, d.name+" must be positive")
+		}
+	}
+	if t.AccessTokenTTL >= t.SessionTTL {
+		problems = append(problems, "tokens.access_token_ttl must be shorter than tokens.session_ttl")
+	}
+
+	problems = append(problems, c.RateLimit.validate()...)
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if !isOrigin(origin) {
+			problems = append(problems, fmt.Sprintf("cors.allowed_origins must be scheme://host[:port], got %q", origin))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func (r RateLimitConfig) validate() []string {
+	var problems []string
+
+	rates := []struct {
+		name  string
+		value Rate
+	}{
+		{"rate_limit.login_per_ip", r.LoginPerIP},
+		{"rate_limit.login_per_email", r.LoginPerEmail},
+		{"rate_limit.authenticate_per_ip", r.AuthenticatePerIP},
+		{"rate_limit.authenticate_failures_per_email", r.AuthenticateFailuresPerEmail},
+	}
+	for _, rate := range rates {
+		if !rate.value.valid() {
+			problems = append(problems, rate.name+" needs a positive limit and window")
+		}
+	}
+
+	l := r.Lockout
+	if l.MaxFailures <= 0 {
+		problems = append(problems, "rate_limit.lockout.max_failures must be positive")
+	}
+	if l.FailureWindow <= 0 || l.Duration <= 0 {
+		problems = append(problems, "rate_limit.lockout.failure_window and duration must be positive")
+	}
+	if l.MaxDuration < l.Duration {
+		problems = append(problems, "rate_limit.lockout.max_duration must not be shorter than duration")
+	}
+
+	return problems
+}
+
+func (r RedisConfig) validate() []string {
+	var problems []string
+
+	switch r.Mode {
+	case "standalone":
+		if r.Addr == "" {
+			problems = append(problems, "cache.redis.addr is required in standalone mode")
+		}
+	case "sentinel":
+		if len(r.Addrs) == 0 {
+			problems = append(problems, "cache.redis.addrs must list the sentinels in sentinel mode")
+		}
+		if r.MasterName == "" {
+			problems = append(problems, "cache.redis.master_name is required in sentinel mode")
+		}
+	case "cluster":
+		if len(r.Addrs) == 0 {
+			problems = append(problems, "cache.redis.addrs must list seed nodes in cluster mode")
+		}
+		if r.DB != 0 {
+			problems = append(problems, "cache.redis.db must be 0 in cluster mode")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cache.redis.mode must be standalone, sentinel or cluster, got %q", r.Mode))
+	}
+
+	if r.DB < 0 {
+		problems = append(problems, "cache.redis.db must not be negative")
+	}
+
+	return problems
+}
+
+// Redacted returns a copy of the settings with secrets hidden, safe to print.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if out.Cache.Redis.Password != "" {
+		out.Cache.Redis.Password = redacted
+	}
+	if out.Cache.Redis.SentinelPassword != "" {
+		out.Cache.Redis.SentinelPassword = redacted
+	}
+	if out.AdminAPIKey != "" {
+		out.AdminAPIKey = redacted
+	}
+	if out.ServiceKey != "" {
+		out.ServiceKey = redacted
+	}
+
+	return &out
+}
+
+// YAML renders the settings in the format Load reads.
+func (c *Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) readEnv() error {
+	var errs []string
+	setString(&c.WebPort, "WEB_PORT")
+	setString(&c.Cache.Driver, "CACHE_DRIVER")
+	setString(&c.Cache.Redis.Mode, "REDIS_MODE")
+	setString(&c.Cache.Redis.Addr, "REDIS_ADDR")
+	setList(&c.Cache.Redis.Addrs, "REDIS_ADDRS")
+	setString(&c.Cache.Redis.MasterName, "REDIS_MASTER_NAME")
+	setString(&c.Cache.Redis.Password, "REDIS_PASSWORD")
+	setString(&c.Cache.Redis.PasswordFile, "REDIS_PASSWORD_FILE")
+	setString(&c.Cache.Redis.SentinelPassword, "REDIS_SENTINEL_PASSWORD")
+	setString(&c.Cache.Redis.SentinelPasswordFile, "REDIS_SENTINEL_PASSWORD_FILE")
+	setInt(&c.Cache.Redis.DB, "REDIS_DB", &errs)
+	setString(&c.Tokens.Issuer, "TOKEN_ISSUER")
+	setDuration(&c.Tokens.SessionTTL, "SESSION_TTL", &errs)
+	setDuration(&c.Tokens.SessionTokenTTL, "SESSION_TOKEN_TTL", &errs)
+	setDuration(&c.Tokens.AccessTokenTTL, "ACCESS_TOKEN_TTL", &errs)
+	setDuration(&c.Tokens.KeyRotationPeriod, "KEY_ROTATION_PERIOD", &errs)
+	setDuration(&c.Tokens.AuthCodeTTL, "AUTH_CODE_TTL", &errs)
+	setRate(&c.RateLimit.LoginPerIP, "RATE_LIMIT_LOGIN_PER_IP", &errs)
+	setRate(&c.RateLimit.LoginPerEmail, "RATE_LIMIT_LOGIN_PER_EMAIL", &errs)
+	setRate(&c.RateLimit.AuthenticatePerIP, "RATE_LIMIT_AUTHENTICATE_PER_IP", &errs)
+	setRate(&c.RateLimit.AuthenticateFailuresPerEmail, "RATE_LIMIT_AUTHENTICATE_FAILURES_PER_EMAIL", &errs)
+	setInt(&c.RateLimit.Lockout.MaxFailures, "LOCKOUT_MAX_FAILURES", &errs)
+	setDuration(&c.RateLimit.Lockout.FailureWindow, "LOCKOUT_FAILURE_WINDOW", &errs)
+	setDuration(&c.RateLimit.Lockout.Duration, "LOCKOUT_DURATION", &errs)
+	setDuration(&c.RateLimit.Lockout.MaxDuration, "LOCKOUT_MAX_DURATION", &errs)
+	setString(&c.AdminAPIKey, "ADMIN_API_KEY")
+	setString(&c.AdminAPIKeyFile, "ADMIN_API_KEY_FILE")
+	setString(&c.ServiceKey, "SERVICE_KEY")
+	setString(&c.ServiceKeyFile, "SERVICE_KEY_FILE")
+	setList(&c.CORS.AllowedOrigins, "CORS_ALLOWED_ORIGINS")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (c *Config) readSecrets() error {
+	r := &c.Cache.Redis
+	if err := readSecretFile(&r.Password, r.PasswordFile, "redis password"); err != nil {
+		return err
+	}
+
+	if err := readSecretFile(&r.SentinelPassword, r.SentinelPasswordFile, "redis sentinel password"); err != nil {
+		return err
+	}
+
+	if err := readSecretFile(&c.AdminAPIKey, c.AdminAPIKeyFile, "admin api key"); err != nil {
+		return err
+	}
+
+	return readSecretFile(&c.ServiceKey, c.ServiceKeyFile, "service key")
+}
+
+// readSecretFile loads the secret stored in file into value. Setting both the
+// secret and its file is ambiguous and refused.
+func readSecretFile(value *string, file string, name string) error {
+	if file == "" {
+		return nil
+	}
+	if *value != "" {
+		return fmt.Errorf("both the %s and its file are set, use one", name)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading %s file: %w", name, err)
+	}
+	*value = strings.TrimRight(string(content), "\r\n")
+
+	return nil
+}
+
+func setString(target *string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+// setList reads a comma separated list, such as host1:26379,host2:26379.
+func setList(target *[]string, name string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*target = list
+}
+
+func setInt(target *int, name string, errs *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s is not a number: %q", name, value))
+		return
+	}
+	*target = n
+}
+
+func setDuration(target *time.Duration, name string, errs *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s is not a duration: %q", name, value))
+		return
+	}
+	*target = d
+}
+
+func setRate(target *Rate, name string, errs *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	rate, err := ParseRate(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s: %s", name, err))
+		return
+	}
+	*target = rate
+}
+
+// isOrigin reports whether raw is an exact web origin, with no wildcard, path
+// or trailing slash.
+func isOrigin(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && !strings.Contains(u.Host, "*")
+}
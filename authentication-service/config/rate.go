@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rate is a number of attempts allowed per window, written as limit/window,
+// e.g. 10/1m.
+type Rate struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRate reads a rate written as limit/window.
+func ParseRate(s string) (Rate, error) {
+	limit, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("rate %q is not written as limit/window", s)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil {
+		return Rate{}, fmt.Errorf("rate %q has an invalid limit", s)
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil {
+		return Rate{}, fmt.Errorf("rate %q has an invalid window", s)
+	}
+
+	return Rate{Limit: n, Window: d}, nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Window)
+}
+
+func (r Rate) MarshalYAML() (any, error) {
+	return r.String(), nil
+}
+
+func (r *Rate) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = rate
+
+	return nil
+}
+
+func (r Rate) valid() bool {
+	return r.Limit > 0 && r.Window > 0
+}
@@ -1,71 +1,35 @@
 package data
 
-import (
-	"context"
-	"encoding/json"
-	"github.com/go-redis/redis/v8"
-	"log"
-	"time"
-)
-
-type Cache struct {
-	Addr    string
-	Client  *redis.Client
-	Context context.Context
-}
-
-func NewCache(addr string) *Cache {
-	return &Cache{Addr: addr}
-}
-func (c *Cache) Connect() error {
-	c.Client = redis.NewClient(&redis.Options{
-		Addr:     c.Addr,
-		Password: "password", // Optional, remove this line if Redis doesn't require authentication
-		DB:       0,          // Use the default Redis database (0 by default)
-	})
-	c.Context = c.Client.Context()
-	_, err := c.Client.Ping(c.Context).Result()
-	if err != nil {
-		log.Printf("Unable to connect to redis %v", err)
-		return err
-	}
-	return nil
-}
-
-func (c *Cache) Get(key string) string {
-	return c.Client.Get(c.Context, key).Val()
-}
-
-func (c *Cache) HGet(key string, field string) string {
-	return c.Client.HGet(c.Context, key, field).Val()
-}
-
-func (c *Cache) Set(key string, value string, duration time.Duration) (string, error) {
-	jsonData, err := json.Marshal(value)
-	if err != nil {
-		log.Printf("error while updating value in redis %v", err)
-		return "", err
-	}
-
-	cmd := c.Client.Set(c.Context, key, jsonData, duration)
-	return cmd.Result()
-}
-
-func (c *Cache) HSetNX(key string, field string, value string, duration time.Duration) (bool, error) {
-	jsonData, err := json.Marshal(value)
-	if err != nil {
-		log.Printf("error while updating value in redis %v", err)
-		return false, err
-	}
-
-	cmd := c.Client.HSetNX(c.Context, key, field, jsonData)
-	return cmd.Result()
-}
-
-func (c *Cache) Del(key string) {
-	c.Client.Del(c.Context, key)
-}
-
-func (c *Cache) HDel(key string, field string) {
-	c.Client.HDel(c.Context, key, field)
+import "time"
+
+// Cache is the key value store the service keeps its state in. Values are
+// stored JSON encoded: Set and friends marshal the value, Get and friends
+// return the raw JSON for the caller to unmarshal. Missing keys read as "".
+type Cache interface {
+	Get(key string) string
+	// GetDel returns the value of key and deletes it in one step, so only one
+	// caller ever sees the value.
+	GetDel(key string) string
+	HGet(key string, field string) string
+
+	// Set stores value under key, expiring after duration unless it is 0.
+	Set(key string, value any, duration time.Duration) (string, error)
+	// SetNX sets key only when it does not exist yet, reporting whether it did.
+	SetNX(key string, value any, duration time.Duration) (bool, error)
+	// HSetNX sets field of the hash key only when it does not exist yet. Hash
+	// fields carry no expiry, duration is ignored.
+	HSetNX(key string, field string, value any, duration time.Duration) (bool, error)
+	// Incr adds one to the integer counter key, starting from 0 when it does
+	// not exist, and returns the new count. The counter expires duration after
+	// its last increment.
+	Incr(key string, duration time.Duration) (int64, error)
+
+	Del(key string)
+	HDel(key string, field string)
+
+	// TTL returns the remaining time to live of key. It is negative when the
+	// key does not exist or has no expiry.
+	TTL(key string) (time.Duration, error)
+	// Scan returns every key matching the glob style pattern match.
+	Scan(match string) ([]string, error)
 }
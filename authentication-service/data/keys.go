@@ -0,0 +1,196 @@
+package data
+
+import (
+	"authentication-service/jwt"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	signingKeysKey     = "signingKeys"
+	signingKeysLockKey = "signingKeys:lock"
+	signingKeyIDBytes  = 8
+
+	// signingKeyGrace keeps keys published a little longer than strictly
+	// needed, covering the delay before a replica notices a rotation is due.
+	signingKeyGrace = 10 * time.Minute
+)
+
+var ErrNoSigningKey = errors.New("no signing key available")
+
+// SigningKey is an Ed25519 key pair used to sign access tokens.
+type SigningKey struct {
+	ID        string    `json:"kid"`
+	Seed      []byte    `json:"seed"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PrivateKey returns the private half of the key pair.
+func (k *SigningKey) PrivateKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(k.Seed)
+}
+
+// PublicKey returns the public half of the key pair.
+func (k *SigningKey) PublicKey() ed25519.PublicKey {
+	return k.PrivateKey().Public().(ed25519.PublicKey)
+}
+
+// KeyStore holds the access token signing keys, shared by every replica
+// through the cache. The newest key signs, and a new key is generated every
+// RotationPeriod. A key stays published for TokenTTL after it stops signing,
+// so tokens it signed keep verifying until they expire.
+type KeyStore struct {
+	Cache          Cache
+	RotationPeriod time.Duration
+	TokenTTL       time.Duration
+
+	mu   sync.RWMutex
+	keys []*SigningKey
+}
+
+func NewKeyStore(cache Cache, rotationPeriod time.Duration, tokenTTL time.Duration) *KeyStore {
+	return &KeyStore{Cache: cache, RotationPeriod: rotationPeriod, TokenTTL: tokenTTL}
+}
+
+// Load reads the keys from the cache, rotating them when the newest key is
+// older than the rotation period.
+func (k *KeyStore) Load() error {
+	keys := k.read()
+
+	if len(keys) == 0 || time.Since(keys[len(keys)-1].CreatedAt) >= k.RotationPeriod {
+		// only one replica rotates, the others pick the new key up on their next load
+		locked, err := k.Cache.SetNX(signingKeysLockKey, true, 10*time.Second)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			keys, err = k.rotate()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return ErrNoSigningKey
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Run reloads the keys every interval until stop is closed.
+func (k *KeyStore) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := k.Load(); err != nil {
+				log.Printf("error while loading signing keys, %s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Current returns the key to sign new tokens with.
+func (k *KeyStore) Current() (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	return k.keys[len(k.keys)-1], nil
+}
+
+// Published returns every key tokens may still be signed with.
+func (k *KeyStore) Published() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*SigningKey, len(k.keys))
+	copy(keys, k.keys)
+
+	return keys
+}
+
+// PublicKey returns the published public key kid. It can be passed to
+// jwt.Parse as a KeyFunc.
+func (k *KeyStore) PublicKey(kid string) (ed25519.PublicKey, error) {
+	for _, key := range k.Published() {
+		if key.ID == kid {
+			return key.PublicKey(), nil
+		}
+	}
+
+	return nil, jwt.ErrUnknownKey
+}
+
+// read returns the unexpired keys stored in the cache, oldest first.
+func (k *KeyStore) read() []*SigningKey {
+	var stored []*SigningKey
+	if err := json.Unmarshal([]byte(k.Cache.Get(signingKeysKey)), &stored); err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	keys := stored[:0]
+	for _, key := range stored {
+		if now.Before(key.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+// rotate adds a fresh key to the stored keys and saves them.
+func (k *KeyStore) rotate() ([]*SigningKey, error) {
+	keys := k.read()
+
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+
+	id, err := randomString(signingKeyIDBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	keys = append(keys, &SigningKey{
+		ID:        id,
+		Seed:      seed,
+		CreatedAt: now,
+		ExpiresAt: now.Add(k.RotationPeriod + k.TokenTTL + signingKeyGrace),
+	})
+
+	if _, err := k.Cache.Set(signingKeysKey, keys, 0); err != nil {
+		return nil, err
+	}
+
+	log.Printf("rotated access token signing key, now signing with %s", id)
+
+	return keys, nil
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"authentication-service/jwt"
+	"testing"
+	"time"
+)
+
+func TestKeyStoreRotation(t *testing.T) {
+	const rotation = 20 * time.Millisecond
+	cache := NewMemoryCache()
+	store := NewKeyStore(cache, rotation, time.Minute)
+
+	if _, err := store.Current(); err != ErrNoSigningKey {
+		t.Fatalf("Current() before Load = %v, want %v", err, ErrNoSigningKey)
+	}
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	token, err := jwt.Sign(&jwt.Claims{Subject: "42", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}, first.ID, first.PrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a replica sharing the cache picks the key up instead of rotating
+	replica := NewKeyStore(cache, rotation, time.Minute)
+	if err := replica.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := replica.Current(); key.ID != first.ID {
+		t.Fatalf("replica signs with %s, want %s", key.ID, first.ID)
+	}
+
+	time.Sleep(rotation)
+	cache.Del(signingKeysLockKey)
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := store.Current()
+	if second.ID == first.ID {
+		t.Fatal("key not rotated after the rotation period")
+	}
+	if published := store.Published(); len(published) != 2 {
+		t.Fatalf("published %d keys, want the old and the new one", len(published))
+	}
+	if _, err := jwt.Parse(token, store.PublicKey); err != nil {
+		t.Errorf("token of the rotated out key = %v, want it still valid", err)
+	}
+	if _, err := store.PublicKey("missing"); err != jwt.ErrUnknownKey {
+		t.Errorf("PublicKey(missing) = %v, want %v", err, jwt.ErrUnknownKey)
+	}
+}
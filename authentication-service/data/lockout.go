@@ -0,0 +1,109 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// lockoutMemory is how long a lockout is remembered once it ended, so an
+// account attacked again soon after gets locked for longer.
+const lockoutMemory = 24 * time.Hour
+
+// LockoutStore locks accounts out of password logins after MaxFailures failed
+// attempts within FailureWindow. The first lockout lasts Duration and every
+// further one within lockoutMemory twice as long as the previous, up to
+// MaxDuration.
+type LockoutStore struct {
+	Cache         Cache
+	MaxFailures   int
+	FailureWindow time.Duration
+	Duration      time.Duration
+	MaxDuration   time.Duration
+}
+
+func NewLockoutStore(cache Cache, maxFailures int, failureWindow time.Duration, duration time.Duration, maxDuration time.Duration) *LockoutStore {
+	return &LockoutStore{
+		Cache:         cache,
+		MaxFailures:   maxFailures,
+		FailureWindow: failureWindow,
+		Duration:      duration,
+		MaxDuration:   maxDuration,
+	}
+}
+
+// LockoutKey is the cache key marking email as locked out. Emails are case
+// insensitive, so they are lowercased to stop variants from dodging the
+// counters.
+func LockoutKey(email string) string {
+	return "lockout:{" + NormalizeEmail(email) + "}"
+}
+
+func lockoutFailuresKey(email string) string {
+	return LockoutKey(email) + ":failures"
+}
+
+func lockoutLevelKey(email string) string {
+	return LockoutKey(email) + ":level"
+}
+
+// NormalizeEmail returns the form of email used to key per user counters.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Locked returns how long email remains locked out, 0 when it is not.
+func (l *LockoutStore) Locked(email string) (time.Duration, error) {
+	ttl, err := l.Cache.TTL(LockoutKey(email))
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// Fail records a failed login of email and returns how long it is now locked
+// out, 0 when it is not.
+func (l *LockoutStore) Fail(email string) (time.Duration, error) {
+	failures, err := l.Cache.Incr(lockoutFailuresKey(email), l.FailureWindow)
+	if err != nil {
+		return 0, err
+	}
+	if failures < int64(l.MaxFailures) {
+		return 0, nil
+	}
+
+	level, err := l.Cache.Incr(lockoutLevelKey(email), lockoutMemory)
+	if err != nil {
+		return 0, err
+	}
+
+	duration := l.Duration
+	for i := int64(1); i < level && duration < l.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > l.MaxDuration {
+		duration = l.MaxDuration
+	}
+
+	if _, err = l.Cache.Set(LockoutKey(email), time.Now().UTC().Add(duration), duration); err != nil {
+		return 0, err
+	}
+	l.Cache.Del(lockoutFailuresKey(email))
+
+	return duration, nil
+}
+
+// Succeed forgets the failed logins of email after it logged in.
+func (l *LockoutStore) Succeed(email string) {
+	l.Cache.Del(lockoutFailuresKey(email))
+	l.Cache.Del(lockoutLevelKey(email))
+}
+
+// Clear lifts the lockout of email and forgets its history.
+func (l *LockoutStore) Clear(email string) {
+	l.Cache.Del(LockoutKey(email))
+	l.Succeed(email)
+}
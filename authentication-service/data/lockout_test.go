@@ -0,0 +1,58 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutEscalation(t *testing.T) {
+	lockouts := NewLockoutStore(NewMemoryCache(), 3, time.Hour, time.Minute, 5*time.Minute)
+
+	// every lockout doubles the previous one, up to the maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		for i := 1; i < 3; i++ {
+			if locked, err := lockouts.Fail("bob@example.com"); err != nil || locked != 0 {
+				t.Fatalf("Fail() #%d = %s, %v, want no lockout yet", i, locked, err)
+			}
+		}
+
+		// emails differing in case or spaces share their counters
+		locked, err := lockouts.Fail("  Bob@Example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != want {
+			t.Fatalf("lockout = %s, want %s", locked, want)
+		}
+		if remaining, _ := lockouts.Locked("bob@example.com"); remaining <= 0 || remaining > want {
+			t.Fatalf("Locked() = %s, want within %s", remaining, want)
+		}
+
+		lockouts.Cache.Del(LockoutKey("bob@example.com"))
+	}
+}
+
+func TestLockoutSucceedAndClear(t *testing.T) {
+	lockouts := NewLockoutStore(NewMemoryCache(), 2, time.Hour, time.Minute, time.Hour)
+
+	lockouts.Fail("bob@example.com")
+	lockouts.Succeed("bob@example.com")
+	if locked, _ := lockouts.Fail("bob@example.com"); locked != 0 {
+		t.Fatalf("Fail() after a success = %s, want the failures forgotten", locked)
+	}
+
+	if locked, _ := lockouts.Fail("bob@example.com"); locked != time.Minute {
+		t.Fatalf("Fail() = %s, want a first lockout of %s", locked, time.Minute)
+	}
+
+	lockouts.Clear("bob@example.com")
+	if locked, _ := lockouts.Locked("bob@example.com"); locked != 0 {
+		t.Errorf("Locked() after Clear = %s, want 0", locked)
+	}
+
+	// the cleared lockout does not count towards the next one
+	lockouts.Fail("bob@example.com")
+	if locked, _ := lockouts.Fail("bob@example.com"); locked != time.Minute {
+		t.Errorf("lockout after Clear = %s, want %s", locked, time.Minute)
+	}
+}
@@ -0,0 +1,353 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often writes purge expired keys, so keys that
+// are never read again do not pile up.
+const memorySweepInterval = time.Minute
+
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+type memoryEntry struct {
+	value     string
+	fields    map[string]string
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryCache is a Cache held in process memory, with the same expiry
+// semantics as redis. It is safe for concurrent use. State is lost on restart
+// and not shared between replicas, so it is meant for tests and local runs.
+type MemoryCache struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:   map[string]*memoryEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (c *MemoryCache) Get(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.lookup(key)
+	if entry == nil || entry.fields != nil {
+		return ""
+	}
+
+	return entry.value
+}
+
+func (c *MemoryCache) GetDel(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.lookup(key)
+	if entry == nil || entry.fields != nil {
+		return ""
+	}
+	delete(c.entries, key)
+
+	return entry.value
+}
+
+func (c *MemoryCache) HGet(key string, field string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.lookup(key)
+	if entry == nil {
+		return ""
+	}
+
+	return entry.fields[field]
+}
+
+func (c *MemoryCache) Set(key string, value any, duration time.Duration) (string, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(key, &memoryEntry{value: string(jsonData)}, duration)
+
+	return "OK", nil
+}
+
+func (c *MemoryCache) SetNX(key string, value any, duration time.Duration) (bool, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lookup(key) != nil {
+		return false, nil
+	}
+	c.store(key, &memoryEntry{value: string(jsonData)}, duration)
+
+	return true, nil
+}
+
+func (c *MemoryCache) HSetNX(key string, field string, value any, duration time.Duration) (bool, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.lookup(key)
+	if entry == nil {
+		entry = &memoryEntry{fields: map[string]string{}}
+		c.store(key, entry, 0)
+	}
+	if entry.fields == nil {
+		return false, ErrWrongType
+	}
+
+	if _, ok := entry.fields[field]; ok {
+		return false, nil
+	}
+	entry.fields[field] = string(jsonData)
+
+	return true, nil
+}
+
+func (c *MemoryCache) Incr(key string, duration time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var count int64
+	if entry := c.lookup(key); entry != nil {
+		if entry.fields != nil {
+			return 0, ErrWrongType
+		}
+		n, err := strconv.ParseInt(entry.value, 10, 64)
+		if err != nil {
+			return 0, ErrWrongType
+		}
+		count = n
+	}
+	count++
+	c.store(key, &memoryEntry{value: strconv.FormatInt(count, 10)}, duration)
+
+	return count, nil
+}
+
+func (c *MemoryCache) Del(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *MemoryCache) HDel(key string, field string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.lookup(key)
+	if entry == nil || entry.fields == nil {
+		return
+	}
+
+	delete(entry.fields, field)
+	if len(entry.fields) == 0 {
+		delete(c.entries, key)
+	}
+}
+
+// TTL follows redis: -2ns when key does not exist, -1ns when it has no expiry.
+func (c *MemoryCache) TTL(key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.lookup(key)
+	if entry == nil {
+		return -2, nil
+	}
+	if entry.expiresAt.IsZero() {
+		return -1, nil
+	}
+
+	return time.Until(entry.expiresAt), nil
+}
+
+func (c *MemoryCache) Scan(match string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key, entry := range c.entries {
+		if entry.expired(now) {
+			delete(c.entries, key)
+			continue
+		}
+		if globMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// lookup returns the live entry of key, dropping it when it has expired. The
+// caller holds the lock.
+func (c *MemoryCache) lookup(key string) *memoryEntry {
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	if entry.expired(time.Now()) {
+		delete(c.entries, key)
+		return nil
+	}
+
+	return entry
+}
+
+// store saves entry under key expiring after duration, and sweeps expired
+// keys once in a while. The caller holds the lock.
+func (c *MemoryCache) store(key string, entry *memoryEntry, duration time.Duration) {
+	now := time.Now()
+	if duration > 0 {
+		entry.expiresAt = now.Add(duration)
+	}
+	c.entries[key] = entry
+
+	if now.Sub(c.lastSweep) >= memorySweepInterval {
+		for k, e := range c.entries {
+			if e.expired(now) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+}
+
+// globMatch reports whether key matches the redis glob pattern: * matches any
+// run of characters, ? any single character, [...] a character class
+// (optionally negated with ^, with a-z ranges) and \ escapes the next
+// character.
+func globMatch(pattern string, key string) bool {
+	p, k := []rune(pattern), []rune(key)
+
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(k); i++ {
+				if globMatch(string(p), string(k[i:])) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(k) == 0 {
+				return false
+			}
+
+		case '[':
+			if len(k) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(p) && p[end] != ']' {
+				if p[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(p) {
+				// unterminated class, match the bracket literally
+				if k[0] != '[' {
+					return false
+				}
+				break
+			}
+			if !classMatch(p[1:end], k[0]) {
+				return false
+			}
+			p = p[end:]
+
+		case '\\':
+			if len(p) > 1 {
+				p = p[1:]
+			}
+			fallthrough
+
+		default:
+			if len(k) == 0 || p[0] != k[0] {
+				return false
+			}
+		}
+
+		p, k = p[1:], k[1:]
+	}
+
+	return len(k) == 0
+}
+
+func classMatch(class []rune, r rune) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' && i+1 < len(class) {
+			i++
+			if class[i] == r {
+				matched = true
+			}
+			continue
+		}
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= r && r <= hi {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == r {
+			matched = true
+		}
+	}
+
+	return matched != negate
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"sessions:*", "sessions:bob@example.com", true},
+		{"sessions:*", "session:bob@example.com", false},
+		{"*", "", true},
+		{"**:bob", "sessions:bob", true},
+		{"*:bob", "sessions:alice", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h[a-b", "h[a-b", true},
+		{"h[a-b", "ha", false},
+		{"h[", "h[", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h\?`, "h?", true},
+		{`trailing\`, `trailing\`, true},
+		{"sessions:{bob}:*", "sessions:{bob}:s1", true},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+		{"ünï*", "ünïcode", true},
+		{"?", "ü", true},
+	}
+
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestClassMatch(t *testing.T) {
+	tests := []struct {
+		class string
+		r     rune
+		want  bool
+	}{
+		{"abc", 'b', true},
+		{"abc", 'd', false},
+		{"^abc", 'd', true},
+		{"^abc", 'a', false},
+		{"a-z", 'm', true},
+		{"a-z", 'M', false},
+		{"0-9a-f", 'c', true},
+		{"a-", '-', true},
+		{`\]`, ']', true},
+		{`\-`, '-', true},
+		{`a\-z`, 'm', false},
+		{"", 'a', false},
+		{"^", 'a', true},
+	}
+
+	for _, tt := range tests {
+		if got := classMatch([]rune(tt.class), tt.r); got != tt.want {
+			t.Errorf("classMatch(%q, %q) = %v, want %v", tt.class, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	c := NewMemoryCache()
+
+	c.Set("short", 1, ttl)
+	c.Set("forever", 2, 0)
+	if _, err := c.Incr("counter", ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.Get("short"); got != "1" {
+		t.Errorf("Get(short) = %q before expiry, want 1", got)
+	}
+	if got, _ := c.TTL("short"); got <= 0 || got > ttl {
+		t.Errorf("TTL(short) = %s, want within %s", got, ttl)
+	}
+	if got, _ := c.TTL("forever"); got != -1 {
+		t.Errorf("TTL(forever) = %s, want -1ns", got)
+	}
+	if got, _ := c.TTL("missing"); got != -2 {
+		t.Errorf("TTL(missing) = %s, want -2ns", got)
+	}
+
+	time.Sleep(ttl)
+
+	if got := c.Get("short"); got != "" {
+		t.Errorf("Get(short) = %q after expiry, want nothing", got)
+	}
+	if got, _ := c.TTL("short"); got != -2 {
+		t.Errorf("TTL(short) = %s after expiry, want -2ns", got)
+	}
+	if ok, _ := c.SetNX("short", 3, ttl); !ok {
+		t.Error("SetNX over an expired key refused")
+	}
+	if n, _ := c.Incr("counter", ttl); n != 1 {
+		t.Errorf("Incr(counter) = %d after expiry, want 1", n)
+	}
+	if keys, _ := c.Scan("*"); len(keys) != 3 {
+		t.Errorf("Scan(*) = %v after expiry, want counter, forever and short", keys)
+	}
+	if got := c.Get("forever"); got != "2" {
+		t.Errorf("Get(forever) = %q, want 2", got)
+	}
+}
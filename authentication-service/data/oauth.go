@@ -0,0 +1,222 @@
+package data
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes third party clients may be granted.
+const (
+	ScopeTweetRead  = "tweet.read"
+	ScopeTweetWrite = "tweet.write"
+	ScopeUsersRead  = "users.read"
+	ScopeUsersWrite = "users.write"
+)
+
+// Grant types supported by the token endpoint.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+const (
+	clientIDBytes     = 16
+	clientSecretBytes = 32
+)
+
+var KnownScopes = []string{ScopeTweetRead, ScopeTweetWrite, ScopeUsersRead, ScopeUsersWrite}
+
+var (
+	ErrClientNotFound = errors.New("client not found")
+	ErrInvalidClient  = errors.New("invalid client credentials")
+	ErrInvalidGrant   = errors.New("invalid or expired authorization code")
+)
+
+// Client is a third party application registered to act on behalf of users.
+// Confidential clients hold a secret, only its hash is stored. Public clients,
+// such as mobile apps, have none and rely on PKCE alone.
+type Client struct {
+	ID           string    `json:"client_id"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	Name         string    `json:"name"`
+	OwnerEmail   string    `json:"owner_email"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	GrantTypes   []string  `json:"grant_types"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Confidential reports whether the client authenticates with a secret.
+func (c *Client) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsRedirect reports whether uri is one of the registered redirect URIs.
+func (c *Client) AllowsRedirect(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsGrant reports whether the client may use grantType.
+func (c *Client) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsScope reports whether every scope of the space separated scope was
+// registered for the client.
+func (c *Client) AllowsScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !contains(c.Scopes, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AuthorizationCode is the server side record of an authorization code,
+// redeemable once at the token endpoint.
+type AuthorizationCode struct {
+	ClientID            string    `json:"client_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scope               string    `json:"scope"`
+	CodeChallenge       string    `json:"code_challenge"`
+	CodeChallengeMethod string    `json:"code_challenge_method"`
+	UserID              int       `json:"user_id"`
+	Email               string    `json:"email"`
+	ExpiresAt           time.Time `json:"expires_at"`
+}
+
+// VerifyChallenge checks verifier against the PKCE challenge of the code.
+// Only the S256 method is supported.
+func (a *AuthorizationCode) VerifyChallenge(verifier string) bool {
+	if a.CodeChallengeMethod != "S256" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(a.CodeChallenge)) == 1
+}
+
+// OAuthStore keeps registered clients and pending authorization codes in the
+// cache. Clients live under oauthClient:<id> and never expire, codes live under
+// oauthCode:<hash> for CodeTTL.
+type OAuthStore struct {
+	Cache   Cache
+	CodeTTL time.Duration
+}
+
+func NewOAuthStore(cache Cache, codeTTL time.Duration) *OAuthStore {
+	return &OAuthStore{Cache: cache, CodeTTL: codeTTL}
+}
+
+func clientKey(id string) string {
+	return "oauthClient:" + id
+}
+
+func authorizationCodeKey(hash string) string {
+	return "oauthCode:" + hash
+}
+
+// RegisterClient stores client under a new id. When confidential is set a
+// secret is generated and returned, it cannot be recovered afterwards.
+func (o *OAuthStore) RegisterClient(client *Client, confidential bool) (string, error) {
+	id, err := randomString(clientIDBytes)
+	if err != nil {
+		return "", err
+	}
+
+	secret := ""
+	if confidential {
+		secret, err = randomString(clientSecretBytes)
+		if err != nil {
+			return "", err
+		}
+		client.SecretHash = HashToken(secret)
+	}
+
+	client.ID = id
+	client.CreatedAt = time.Now().UTC()
+
+	if _, err = o.Cache.Set(clientKey(id), client, 0); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// GetClient returns the client id.
+func (o *OAuthStore) GetClient(id string) (*Client, error) {
+	var client Client
+	if err := json.Unmarshal([]byte(o.Cache.Get(clientKey(id))), &client); err != nil {
+		return nil, ErrClientNotFound
+	}
+
+	return &client, nil
+}
+
+// AuthenticateClient returns the client id when secret matches it. Public
+// clients authenticate with their id alone.
+func (o *OAuthStore) AuthenticateClient(id string, secret string) (*Client, error) {
+	client, err := o.GetClient(id)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+
+	if !client.Confidential() {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// CreateCode stores grant and returns the plaintext authorization code for it.
+func (o *OAuthStore) CreateCode(grant *AuthorizationCode) (string, error) {
+	code, err := randomString(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	grant.ExpiresAt = time.Now().UTC().Add(o.CodeTTL)
+
+	if _, err = o.Cache.Set(authorizationCodeKey(HashToken(code)), grant, o.CodeTTL); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// RedeemCode returns the grant of code and deletes it, so a code can only be
+// exchanged once.
+func (o *OAuthStore) RedeemCode(code string) (*AuthorizationCode, error) {
+	var grant AuthorizationCode
+	err := json.Unmarshal([]byte(o.Cache.GetDel(authorizationCodeKey(HashToken(code)))), &grant)
+	if err != nil || !time.Now().Before(grant.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+
+	return &grant, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the outcome of an attempt checked against a RateLimiter, with
+// what a client needs to know to pace itself.
+type RateLimit struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the attempts counted so far have left the window.
+	Reset time.Duration
+	// RetryAfter is how long a denied client has to wait before trying again.
+	RetryAfter time.Duration
+}
+
+// RateLimiter allows up to Limit attempts per key in any sliding Window. It
+// keeps one counter per fixed window and estimates the sliding count as the
+// current counter plus the share of the previous one still inside the window,
+// which needs two small keys per client whatever the limit.
+type RateLimiter struct {
+	Cache  Cache
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+func NewRateLimiter(cache Cache, name string, limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{Cache: cache, Name: name, Limit: limit, Window: window}
+}
+
+// rateLimitKey is the counter of key for the fixed window number index. The
+// key is a hash tag so both windows of a client live in one cluster slot.
+func (l *RateLimiter) rateLimitKey(key string, index int64) string {
+	return "rateLimit:" + l.Name + ":{" + key + "}:" + strconv.FormatInt(index, 10)
+}
+
+// Allow counts an attempt for key unless it would go over the limit.
+func (l *RateLimiter) Allow(key string) (*RateLimit, error) {
+	return l.check(key, true)
+}
+
+// Peek reports whether an attempt for key would be allowed, without counting
+// one.
+func (l *RateLimiter) Peek(key string) (*RateLimit, error) {
+	return l.check(key, false)
+}
+
+// Reset forgets the attempts of key.
+func (l *RateLimiter) Reset(key string) {
+	index := time.Now().UnixNano() / int64(l.Window)
+	l.Cache.Del(l.rateLimitKey(key, index))
+	l.Cache.Del(l.rateLimitKey(key, index-1))
+}
+
+func (l *RateLimiter) check(key string, count bool) (*RateLimit, error) {
+	now := time.Now().UnixNano()
+	index := now / int64(l.Window)
+	elapsed := time.Duration(now % int64(l.Window))
+
+	previous := l.count(key, index-1)
+	current := l.count(key, index)
+	weight := 1 - float64(elapsed)/float64(l.Window)
+
+	result := &RateLimit{
+		Limit: l.Limit,
+		Reset: 2*l.Window - elapsed,
+	}
+
+	estimate := float64(previous)*weight + float64(current)
+	if estimate+1 > float64(l.Limit) {
+		result.RetryAfter = l.retryAfter(previous, current, elapsed)
+		return result, nil
+	}
+
+	if count {
+		n, err := l.Cache.Incr(l.rateLimitKey(key, index), 2*l.Window)
+		if err != nil {
+			return nil, err
+		}
+		// concurrent attempts may have been counted meanwhile
+		estimate = float64(previous)*weight + float64(n)
+		if estimate > float64(l.Limit) {
+			result.RetryAfter = l.retryAfter(previous, n, elapsed)
+			return result, nil
+		}
+	}
+
+	result.Allowed = true
+	result.Remaining = l.Limit - int(math.Ceil(estimate))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	return result, nil
+}
+
+// retryAfter returns how long until the estimate leaves room for one more
+// attempt, assuming no attempt is counted meanwhile.
+func (l *RateLimiter) retryAfter(previous int64, current int64, elapsed time.Duration) time.Duration {
+	room := float64(l.Limit - 1)
+	window := float64(l.Window)
+
+	// the previous window slides out during the current one
+	if float64(current) <= room && previous > 0 {
+		wait := window*(1-(room-float64(current))/float64(previous)) - float64(elapsed)
+		if wait < 0 {
+			wait = 0
+		}
+		return time.Duration(wait)
+	}
+
+	// the current window has to slide out during the next one
+	wait := float64(l.Window - elapsed)
+	if current > 0 {
+		wait += window * (1 - room/float64(current))
+	}
+
+	return time.Duration(wait)
+}
+
+func (l *RateLimiter) count(key string, index int64) int64 {
+	n, err := strconv.ParseInt(l.Cache.Get(l.rateLimitKey(key, index)), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
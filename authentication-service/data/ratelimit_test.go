@@ -0,0 +1,69 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryCache(), "test", 3, time.Hour)
+
+	for want := 2; want >= 0; want-- {
+		limit, err := limiter.Allow("10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !limit.Allowed || limit.Remaining != want {
+			t.Fatalf("Allow() = %+v, want allowed with %d remaining", limit, want)
+		}
+	}
+
+	limit, err := limiter.Allow("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit.Allowed || limit.RetryAfter <= 0 || limit.RetryAfter > 2*time.Hour {
+		t.Fatalf("Allow() over the limit = %+v, want refused with a retry delay", limit)
+	}
+
+	for i := 0; i < 2; i++ {
+		if limit, _ := limiter.Peek("10.0.0.2"); !limit.Allowed || limit.Remaining != 3 {
+			t.Errorf("Peek() of another key = %+v, want allowed with nothing counted", limit)
+		}
+	}
+
+	limiter.Reset("10.0.0.1")
+	if limit, _ := limiter.Allow("10.0.0.1"); !limit.Allowed {
+		t.Errorf("Allow() after Reset = %+v, want allowed", limit)
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryCache(), "test", 10, time.Minute)
+
+	tests := []struct {
+		name     string
+		previous int64
+		current  int64
+		elapsed  time.Duration
+		want     time.Duration
+	}{
+		// at 36s the previous window weighs 0.4, 4 + 5 leaves room for one
+		{"previous window slides out", 10, 5, 30 * time.Second, 6 * time.Second},
+		{"previous window full", 10, 0, 0, 6 * time.Second},
+		{"end of the window", 10, 9, 59 * time.Second, time.Second},
+		{"already room", 10, 0, 30 * time.Second, 0},
+		// 45s to the next window, then 6s for the full window to weigh 9
+		{"current window full", 0, 10, 15 * time.Second, 51 * time.Second},
+		{"both windows full", 5, 10, 15 * time.Second, 51 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limiter.retryAfter(tt.previous, tt.current, tt.elapsed)
+			if diff := got - tt.want; diff < -time.Millisecond || diff > time.Millisecond {
+				t.Errorf("retryAfter(%d, %d, %s) = %s, want %s", tt.previous, tt.current, tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topologies a RedisCache can connect to.
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+// redisScanCount is the amount of keys asked for on every SCAN round trip.
+const redisScanCount = 100
+
+// RedisOptions describe how to reach redis. Addrs holds the single server in
+// standalone mode, the sentinels in sentinel mode and the seed nodes in
+// cluster mode.
+type RedisOptions struct {
+	Mode             string
+	Addrs            []string
+	MasterName       string
+	Password         string
+	SentinelPassword string
+	DB               int
+}
+
+// RedisCache is the Cache backed by redis, either a single server, a master
+// watched by sentinels or a cluster.
+type RedisCache struct {
+	Options RedisOptions
+	Client  redis.UniversalClient
+	Context context.Context
+}
+
+func NewRedisCache(options RedisOptions) *RedisCache {
+	return &RedisCache{Options: options}
+}
+
+func (c *RedisCache) Connect() error {
+	o := c.Options
+	switch o.Mode {
+	case RedisSentinel:
+		c.Client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       o.MasterName,
+			SentinelAddrs:    o.Addrs,
+			SentinelPassword: o.SentinelPassword,
+			Password:         o.Password,
+			DB:               o.DB,
+		})
+	case RedisCluster:
+		c.Client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    o.Addrs,
+			Password: o.Password,
+		})
+	case RedisStandalone, "":
+		if len(o.Addrs) != 1 {
+			return fmt.Errorf("standalone redis needs exactly one address, got %d", len(o.Addrs))
+		}
+		c.Client = redis.NewClient(&redis.Options{
+			Addr:     o.Addrs[0],
+			Password: o.Password, // empty when redis doesn't require authentication
+			DB:       o.DB,
+		})
+	default:
+		return fmt.Errorf("unknown redis mode %q", o.Mode)
+	}
+
+	c.Context = context.Background()
+	_, err := c.Client.Ping(c.Context).Result()
+	if err != nil {
+		log.Printf("Unable to connect to redis %v", err)
+		return err
+	}
+	return nil
+}
+
+func (c *RedisCache) Get(key string) string {
+	return c.Client.Get(c.Context, key).Val()
+}
+
+func (c *RedisCache) GetDel(key string) string {
+	return c.Client.GetDel(c.Context, key).Val()
+}
+
+func (c *RedisCache) HGet(key string, field string) string {
+	return c.Client.HGet(c.Context, key, field).Val()
+}
+
+func (c *RedisCache) Set(key string, value any, duration time.Duration) (string, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("error while updating value in redis %v", err)
+		return "", err
+	}
+
+	cmd := c.Client.Set(c.Context, key, jsonData, duration)
+	return cmd.Result()
+}
+
+func (c *RedisCache) SetNX(key string, value any, duration time.Duration) (bool, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("error while updating value in redis %v", err)
+		return false, err
+	}
+
+	cmd := c.Client.SetNX(c.Context, key, jsonData, duration)
+	return cmd.Result()
+}
+
+func (c *RedisCache) HSetNX(key string, field string, value any, duration time.Duration) (bool, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("error while updating value in redis %v", err)
+		return false, err
+	}
+
+	cmd := c.Client.HSetNX(c.Context, key, field, jsonData)
+	return cmd.Result()
+}
+
+func (c *RedisCache) Incr(key string, duration time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := c.Client.TxPipelined(c.Context, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(c.Context, key)
+		pipe.Expire(c.Context, key, duration)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+func (c *RedisCache) Del(key string) {
+	c.Client.Del(c.Context, key)
+}
+
+func (c *RedisCache) HDel(key string, field string) {
+	c.Client.HDel(c.Context, key, field)
+}
+
+func (c *RedisCache) TTL(key string) (time.Duration, error) {
+	return c.Client.TTL(c.Context, key).Result()
+}
+
+// Scan walks the keyspace with SCAN. A cluster spreads keys over its masters,
+// so each of them is scanned, unless match pins a hash tag: every matching key
+// then lives in the slot of that tag and only its master is asked.
+func (c *RedisCache) Scan(match string) ([]string, error) {
+	cluster, ok := c.Client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(c.Context, c.Client, match)
+	}
+
+	if tagKey, ok := hashTagKey(match); ok {
+		master, err := cluster.MasterForKey(c.Context, tagKey)
+		if err != nil {
+			return nil, err
+		}
+		return scanNode(c.Context, master, match)
+	}
+
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	keys := []string{}
+	err := cluster.ForEachMaster(c.Context, func(ctx context.Context, master *redis.Client) error {
+		found, err := scanNode(ctx, master, match)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		// a key being migrated between slots can show up on two masters
+		for _, key := range found {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func scanNode(ctx context.Context, client redis.Cmdable, match string) ([]string, error) {
+	var keys []string
+	iter := client.Scan(ctx, 0, match, redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	return keys, iter.Err()
+}
+
+// hashTagKey returns a key hashing to the same slot as every key matching the
+// glob pattern match, when match holds a literal hash tag: the text between
+// the first { and the next }, which redis hashes instead of the whole key.
+func hashTagKey(match string) (string, bool) {
+	open := strings.IndexByte(match, '{')
+	if open < 0 || strings.ContainsAny(match[:open], "*?[\\") {
+		// keys matching a pattern before the brace may hold an earlier {
+		return "", false
+	}
+	closing := strings.IndexByte(match[open+1:], '}')
+	if closing <= 0 {
+		return "", false
+	}
+
+	var tag strings.Builder
+	pattern := match[:open+1+closing]
+	for i := open + 1; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return "", false
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return "", false
+			}
+		}
+		tag.WriteByte(pattern[i])
+	}
+
+	return "{" + tag.String() + "}", true
+}
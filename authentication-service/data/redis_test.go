@@ -0,0 +1,32 @@
+package data
+
+import "testing"
+
+func TestHashTagKey(t *testing.T) {
+	tests := []struct {
+		match  string
+		want   string
+		wantOK bool
+	}{
+		{"sessions:{bob@example.com}:*", "{bob@example.com}", true},
+		{"{bob}", "{bob}", true},
+		{"refresh:{bob}:{alice}", "{bob}", true},
+		{`sessions:{b\*b}:*`, "{b*b}", true},
+		{"sessions:*", "", false},
+		{"*:{bob}:*", "", false},
+		{"s?:{bob}", "", false},
+		{"sessions:{}:*", "", false},
+		{"sessions:{bob", "", false},
+		{"sessions:{b*}:x", "", false},
+		{"sessions:{b?b}:x", "", false},
+		{"sessions:{b[ab]}:x", "", false},
+		{`sessions:{bob\}:x`, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := hashTagKey(tt.match)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("hashTagKey(%q) = %q, %v, want %q, %v", tt.match, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
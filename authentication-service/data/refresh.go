@@ -0,0 +1,101 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrRefreshTokenReused = errors.New("refresh token reused, session revoked")
+
+// RefreshToken is the server side record of a single use refresh token. Every
+// refresh token of a session belongs to the same family, the session itself:
+// presenting a token a second time means it leaked, so the whole session is
+// revoked.
+type RefreshToken struct {
+	Hash      string    `json:"hash"`
+	Email     string    `json:"email"`
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RefreshTokenKey is the cache key holding the refresh token record for a
+// token hash.
+func RefreshTokenKey(hash string) string {
+	return "refresh:" + hash
+}
+
+// refreshTokenUsedKey marks a refresh token as spent. It is kept apart from
+// the record so a token can be claimed atomically with SETNX.
+func refreshTokenUsedKey(hash string) string {
+	return "refresh:" + hash + ":used"
+}
+
+// IssueRefreshToken hands out a new refresh token for session, replacing the
+// current one, and extends the session for another TTL.
+func (s *SessionStore) IssueRefreshToken(session *Session) (string, error) {
+	token, err := randomString(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	record := &RefreshToken{
+		Hash:      HashToken(token),
+		Email:     session.Email,
+		SessionID: session.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.TTL),
+	}
+
+	if _, err = s.Cache.Set(RefreshTokenKey(record.Hash), record, s.TTL); err != nil {
+		return "", err
+	}
+
+	session.RefreshHash = record.Hash
+	session.ExpiresAt = record.ExpiresAt
+
+	if _, err = s.Cache.Set(SessionKey(session.Email, session.ID), session, s.TTL); err != nil {
+		s.Cache.Del(RefreshTokenKey(record.Hash))
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Refresh spends token and returns its session together with the refresh
+// token replacing it. A token that was already spent revokes its session.
+func (s *SessionStore) Refresh(token string) (*Session, string, error) {
+	hash := HashToken(token)
+
+	var record RefreshToken
+	err := json.Unmarshal([]byte(s.Cache.Get(RefreshTokenKey(hash))), &record)
+	if err != nil || !time.Now().Before(record.ExpiresAt) {
+		return nil, "", ErrInvalidToken
+	}
+
+	claimed, err := s.Cache.SetNX(refreshTokenUsedKey(hash), true, time.Until(record.ExpiresAt))
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := s.Get(record.Email, record.SessionID)
+	if err != nil {
+		return nil, "", ErrInvalidToken
+	}
+
+	if !claimed || session.RefreshHash != hash {
+		log.Printf("refresh token reused for session %s of %s, revoking it", session.ID, session.Email)
+		s.Revoke(session.Email, session.ID)
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	next, err := s.IssueRefreshToken(session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, next, nil
+}
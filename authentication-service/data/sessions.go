@@ -0,0 +1,231 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// sessionIDBytes is the amount of randomness in a session id.
+const sessionIDBytes = 16
+
+// lastSeenResolution limits how often the last seen time of a session is
+// written.
+const lastSeenResolution = time.Minute
+
+var (
+	ErrInvalidToken    = errors.New("invalid or expired token")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Session is a single login of a user. Every login gets its own session and
+// token, so a user can be signed in on several devices at once.
+type Session struct {
+	ID          string    `json:"id"`
+	UserID      int       `json:"user_id"`
+	Email       string    `json:"email"`
+	Device      string    `json:"device"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Roles       []string  `json:"roles,omitempty"`
+	Permissions []string  `json:"permissions,omitempty"`
+	TokenHash   string    `json:"token_hash,omitempty"`
+	RefreshHash string    `json:"refresh_hash,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// SessionStore keeps sessions in the cache. A session lives under
+// session:{<email>}:<id> for TTL, extended every time its refresh token is
+// rotated. Its token lives under token:<hash> for TokenTTL. Its last seen
+// time lives apart under seen:{<email>}:<id>, so recording an access never
+// rewrites the session and cannot undo a concurrent refresh.
+type SessionStore struct {
+	Cache    Cache
+	TTL      time.Duration
+	TokenTTL time.Duration
+}
+
+func NewSessionStore(cache Cache, ttl time.Duration, tokenTTL time.Duration) *SessionStore {
+	return &SessionStore{Cache: cache, TTL: ttl, TokenTTL: tokenTTL}
+}
+
+// SessionKey is the cache key holding session id of email. The email is a
+// redis hash tag, so on a cluster all the sessions of a user share one slot
+// and listing them only scans the master owning it.
+func SessionKey(email string, id string) string {
+	return "session:{" + email + "}:" + id
+}
+
+// lastSeenKey is the cache key holding the last seen time of session id of
+// email.
+func lastSeenKey(email string, id string) string {
+	return "seen:{" + email + "}:" + id
+}
+
+// Create starts session, filling in its id and timestamps, and returns the
+// plaintext token identifying it.
+func (s *SessionStore) Create(session *Session) (string, error) {
+	id, err := randomString(sessionIDBytes)
+	if err != nil {
+		return "", err
+	}
+
+	token, record, err := NewToken(session.Email, id, s.TokenTTL)
+	if err != nil {
+		return "", err
+	}
+
+	session.ID = id
+	session.TokenHash = record.Hash
+	session.CreatedAt = record.CreatedAt
+	session.LastSeenAt = record.CreatedAt
+	session.ExpiresAt = record.CreatedAt.Add(s.TTL)
+
+	if _, err = s.Cache.Set(SessionKey(session.Email, id), session, s.TTL); err != nil {
+		return "", err
+	}
+
+	if _, err = s.Cache.Set(TokenKey(record.Hash), record, s.TokenTTL); err != nil {
+		s.Cache.Del(SessionKey(session.Email, id))
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Find returns the live session identified by token.
+func (s *SessionStore) Find(token string) (*Session, error) {
+	var record Token
+	err := json.Unmarshal([]byte(s.Cache.Get(TokenKey(HashToken(token)))), &record)
+	if err != nil || record.Expired() {
+		return nil, ErrInvalidToken
+	}
+
+	session, err := s.Get(record.Email, record.SessionID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return session, nil
+}
+
+// Authenticate returns the live session identified by token and records the
+// access as its last seen time.
+func (s *SessionStore) Authenticate(token string) (*Session, error) {
+	session, err := s.Find(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Since(session.LastSeenAt) > lastSeenResolution {
+		session.LastSeenAt = time.Now().UTC()
+		// outlives the session by at most TTL, and is dropped with it on revoke
+		_, err := s.Cache.Set(lastSeenKey(session.Email, session.ID), session.LastSeenAt, s.TTL)
+		if err != nil {
+			log.Printf("error while updating session %s, %s", session.ID, err)
+		}
+	}
+
+	return session, nil
+}
+
+// Get returns the session id of email.
+func (s *SessionStore) Get(email string, id string) (*Session, error) {
+	var session Session
+	err := json.Unmarshal([]byte(s.Cache.Get(SessionKey(email, id))), &session)
+	if err != nil || session.Email != email {
+		return nil, ErrSessionNotFound
+	}
+	s.lastSeen(&session)
+
+	return &session, nil
+}
+
+// List returns every live session of email.
+func (s *SessionStore) List(email string) ([]*Session, error) {
+	keys, err := s.Cache.Scan(SessionKey(escapeGlob(email), "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*Session{}
+	for _, key := range keys {
+		var session Session
+		if err := json.Unmarshal([]byte(s.Cache.Get(key)), &session); err != nil {
+			// expired between the scan and the read
+			continue
+		}
+		s.lastSeen(&session)
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, nil
+}
+
+// Revoke ends the session id of email.
+func (s *SessionStore) Revoke(email string, id string) error {
+	session, err := s.Get(email, id)
+	if err != nil {
+		return err
+	}
+
+	s.Cache.Del(TokenKey(session.TokenHash))
+	if session.RefreshHash != "" {
+		s.Cache.Del(RefreshTokenKey(session.RefreshHash))
+	}
+	s.Cache.Del(SessionKey(email, id))
+	s.Cache.Del(lastSeenKey(email, id))
+
+	return nil
+}
+
+// RevokeAll ends every session of email except the one with id except, which
+// may be empty to end them all. It returns the number of sessions ended.
+func (s *SessionStore) RevokeAll(email string, except string) (int, error) {
+	sessions, err := s.List(email)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == except {
+			continue
+		}
+		if err := s.Revoke(email, session.ID); err == nil {
+			revoked++
+		}
+	}
+
+	return revoked, nil
+}
+
+// lastSeen fills in the last seen time of session recorded by Authenticate,
+// when there is one.
+func (s *SessionStore) lastSeen(session *Session) {
+	var seen time.Time
+	if err := json.Unmarshal([]byte(s.Cache.Get(lastSeenKey(session.Email, session.ID))), &seen); err != nil {
+		return
+	}
+	if seen.After(session.LastSeenAt) {
+		session.LastSeenAt = seen
+	}
+}
+
+// escapeGlob escapes the characters redis treats as glob patterns in s.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
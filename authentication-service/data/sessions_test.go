@@ -0,0 +1,61 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthenticateKeepsRefreshState(t *testing.T) {
+	cache := NewMemoryCache()
+	store := NewSessionStore(cache, time.Hour, time.Hour)
+
+	session := &Session{UserID: 1, Email: "ada@example.com"}
+	token, err := store.Create(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// seen long enough ago for the next access to be recorded
+	session.LastSeenAt = time.Now().UTC().Add(-2 * lastSeenResolution)
+	if _, err := cache.Set(SessionKey(session.Email, session.ID), session, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := store.IssueRefreshToken(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := SessionKey(session.Email, session.ID)
+	before := cache.Get(key)
+
+	authenticated, err := store.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(authenticated.LastSeenAt) > time.Second {
+		t.Errorf("LastSeenAt = %v, want now", authenticated.LastSeenAt)
+	}
+
+	if after := cache.Get(key); after != before {
+		t.Errorf("Authenticate rewrote the session\nbefore %s\nafter  %s", before, after)
+	}
+
+	stored, err := store.Get(session.Email, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.LastSeenAt.Equal(authenticated.LastSeenAt) {
+		t.Errorf("stored LastSeenAt = %v, want %v", stored.LastSeenAt, authenticated.LastSeenAt)
+	}
+
+	if _, _, err := store.Refresh(refresh); err != nil {
+		t.Errorf("Refresh after Authenticate = %v, want nil", err)
+	}
+
+	if err := store.Revoke(session.Email, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if seen := cache.Get(lastSeenKey(session.Email, session.ID)); seen != "" {
+		t.Errorf("last seen time %s kept after revoke", seen)
+	}
+}
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// tokenBytes is the amount of randomness in a session token (256 bits).
+const tokenBytes = 32
+
+// Token is the server side record of an issued session token. Only the
+// SHA-256 hash of the token is kept, the plaintext is handed to the client once.
+type Token struct {
+	Hash      string    `json:"hash"`
+	Email     string    `json:"email"`
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewToken generates a random token for the session sessionID of email valid
+// for ttl. It returns the plaintext token to hand to the client and the record
+// to store.
+func NewToken(email string, sessionID string, ttl time.Duration) (string, *Token, error) {
+	plaintext, err := randomString(tokenBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+
+	return plaintext, &Token{
+		Hash:      HashToken(plaintext),
+		Email:     email,
+		SessionID: sessionID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a plaintext token.
+func HashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired reports whether the token is past its expiry time.
+func (t *Token) Expired() bool {
+	return !time.Now().Before(t.ExpiresAt)
+}
+
+// TokenKey is the cache key holding the token record for a token hash.
+func TokenKey(hash string) string {
+	return "token:" + hash
+}
+
+// randomString returns n bytes read from the CSPRNG, base64url encoded.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,157 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWK is an Ed25519 public key in JSON Web Key format (RFC 8037).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the JWK form of the public key kid.
+func NewJWK(kid string, key ed25519.PublicKey) JWK {
+	return JWK{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         base64.RawURLEncoding.EncodeToString(key),
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: Algorithm,
+	}
+}
+
+// PublicKey decodes the key material of k.
+func (k JWK) PublicKey() (ed25519.PublicKey, error) {
+	if k.KeyType != "OKP" || k.Curve != "Ed25519" {
+		return nil, fmt.Errorf("jwt: unsupported key %s/%s", k.KeyType, k.Curve)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("jwt: invalid Ed25519 key")
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+// RemoteKeySet verifies tokens with the keys published at a JWKS URL. Keys are
+// cached for MaxAge, and a token signed by an unknown key triggers a refetch
+// so rotated keys are picked up straight away. Refetches are spaced at least
+// MinRefresh apart so garbage tokens cannot hammer the key server.
+type RemoteKeySet struct {
+	URL        string
+	Client     *http.Client
+	MaxAge     time.Duration
+	MinRefresh time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]ed25519.PublicKey
+	fetched   time.Time
+	attempted time.Time
+}
+
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:        url,
+		Client:     &http.Client{Timeout: 5 * time.Second},
+		MaxAge:     5 * time.Minute,
+		MinRefresh: 10 * time.Second,
+	}
+}
+
+// Key returns the public key kid, fetching the key set when needed. It can be
+// passed to Parse as a KeyFunc.
+func (s *RemoteKeySet) Key(kid string) (ed25519.PublicKey, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetched)
+	sinceAttempt := time.Since(s.attempted)
+	s.mu.RUnlock()
+
+	if ok && age < s.MaxAge {
+		return key, nil
+	}
+
+	if sinceAttempt >= s.MinRefresh {
+		if err := s.refresh(); err != nil {
+			// keep verifying with the keys we have while the key server is unreachable
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok = s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// Verify parses token with the keys of the set.
+func (s *RemoteKeySet) Verify(token string) (*Claims, error) {
+	return Parse(token, s.Key)
+}
+
+func (s *RemoteKeySet) refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another caller refreshed while we waited for the lock
+	if time.Since(s.attempted) < s.MinRefresh {
+		return nil
+	}
+	s.attempted = time.Now()
+
+	response, err := s.Client.Get(s.URL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwt: fetching %s: status %d", s.URL, response.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]ed25519.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	s.keys = keys
+	s.fetched = time.Now()
+
+	return nil
+}
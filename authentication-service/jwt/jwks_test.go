@@ -0,0 +1,116 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyServer publishes a JWKS whose keys the test swaps, counting fetches.
+type keyServer struct {
+	mu      sync.Mutex
+	keys    []JWK
+	fetches int
+	down    bool
+}
+
+func (s *keyServer) publish(keys ...JWK) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetches++
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(JWKS{Keys: s.keys})
+}
+
+func TestRemoteKeySetRotation(t *testing.T) {
+	public1, private1 := newTestKey(t)
+	public2, private2 := newTestKey(t)
+	sign := func(kid string, key ed25519.PrivateKey) string {
+		now := time.Now()
+		token, err := Sign(&Claims{Subject: kid, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}, kid, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	token1, token2 := sign("k1", private1), sign("k2", private2)
+
+	keys := &keyServer{}
+	keys.publish(NewJWK("k1", public1))
+	server := httptest.NewServer(keys)
+	defer server.Close()
+
+	set := NewRemoteKeySet(server.URL)
+	set.MinRefresh = 0
+
+	if _, err := set.Verify(token1); err != nil {
+		t.Fatalf("Verify(k1) = %v", err)
+	}
+	if _, err := set.Verify(token1); err != nil || keys.fetches != 1 {
+		t.Fatalf("Verify(k1) again = %v after %d fetches, want the cached key", err, keys.fetches)
+	}
+
+	// a token of a key rotated in since the last fetch triggers a refetch
+	keys.publish(NewJWK("k1", public1), NewJWK("k2", public2))
+	if _, err := set.Verify(token2); err != nil || keys.fetches != 2 {
+		t.Fatalf("Verify(k2) = %v after %d fetches, want a refetch", err, keys.fetches)
+	}
+
+	// once the old key is retired and the cache is stale, its tokens fail
+	keys.publish(NewJWK("k2", public2))
+	set.MaxAge = 0
+	if _, err := set.Verify(token1); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Verify(k1) after retirement = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// the keys at hand keep working while the key server is down
+	keys.mu.Lock()
+	keys.down = true
+	keys.mu.Unlock()
+	if _, err := set.Verify(token2); err != nil {
+		t.Errorf("Verify(k2) with the key server down = %v", err)
+	}
+}
+
+func TestRemoteKeySetMinRefresh(t *testing.T) {
+	public, _ := newTestKey(t)
+	_, unknown := newTestKey(t)
+
+	keys := &keyServer{}
+	keys.publish(NewJWK("k1", public))
+	server := httptest.NewServer(keys)
+	defer server.Close()
+
+	set := NewRemoteKeySet(server.URL)
+	set.MinRefresh = time.Hour
+
+	now := time.Now()
+	token, err := Sign(&Claims{IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}, "garbage", unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := set.Verify(token); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("Verify(unknown key) = %v, want %v", err, ErrUnknownKey)
+		}
+	}
+	if keys.fetches != 1 {
+		t.Errorf("fetched the key set %d times, want once", keys.fetches)
+	}
+}
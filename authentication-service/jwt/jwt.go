@@ -0,0 +1,168 @@
+// Package jwt signs and verifies the access tokens minted by the
+// authentication service. Tokens are compact JWS using EdDSA (Ed25519)
+// signatures, so any service holding the published public keys can verify
+// them without calling the authentication service.
+package jwt
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Algorithm is the only signing algorithm accepted.
+const Algorithm = "EdDSA"
+
+// leeway tolerates small clock differences between services.
+const leeway = 30 * time.Second
+
+var (
+	ErrMalformed  = errors.New("jwt: malformed token")
+	ErrAlgorithm  = errors.New("jwt: unexpected signing algorithm")
+	ErrUnknownKey = errors.New("jwt: unknown signing key")
+	ErrSignature  = errors.New("jwt: invalid signature")
+	ErrExpired    = errors.New("jwt: token expired")
+	ErrNotYet     = errors.New("jwt: token used before issued")
+)
+
+// Claims carried by an access token.
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	Email     string `json:"email,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	// Roles of the user and the permissions they grant. Only tokens of first
+	// party sessions carry them.
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	ID          string   `json:"jti,omitempty"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+}
+
+// Scopes returns the space separated scope claim as a slice.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether scope was granted to the token.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasPermission reports whether the roles of the user grant permission.
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Valid checks the time based claims against now.
+func (c *Claims) Valid(now time.Time) error {
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return ErrExpired
+	}
+	if now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrNotYet
+	}
+
+	return nil
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// KeyFunc returns the public key for the key id found in a token header.
+type KeyFunc func(kid string) (ed25519.PublicKey, error)
+
+// Sign returns claims as a compact JWS signed by key, advertising kid as the
+// signing key id.
+func Sign(claims *Claims, kid string, key ed25519.PrivateKey) (string, error) {
+	h, err := json.Marshal(header{Algorithm: Algorithm, Type: "JWT", KeyID: kid})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(h) + "." + encode(c)
+	signature := ed25519.Sign(key, []byte(signingInput))
+
+	return signingInput + "." + encode(signature), nil
+}
+
+// Parse verifies the signature of token with the key returned by keys and
+// checks it has not expired.
+func Parse(token string, keys KeyFunc) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+	if h.Algorithm != Algorithm {
+		return nil, ErrAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	key, err := keys(h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrSignature
+	}
+
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if err := claims.Valid(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJSON(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,100 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return public, private
+}
+
+func TestSignParse(t *testing.T) {
+	public, private := newTestKey(t)
+	_, other := newTestKey(t)
+	keys := func(kid string) (ed25519.PublicKey, error) {
+		if kid != "k1" {
+			return nil, ErrUnknownKey
+		}
+		return public, nil
+	}
+
+	now := time.Now()
+	claims := func(issuedAt time.Time, ttl time.Duration) *Claims {
+		return &Claims{Subject: "42", Email: "bob@example.com", Scope: "users.read", IssuedAt: issuedAt.Unix(), ExpiresAt: issuedAt.Add(ttl).Unix()}
+	}
+	sign := func(c *Claims, kid string, key ed25519.PrivateKey) string {
+		token, err := Sign(c, kid, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(claims(now, time.Minute), "k1", private)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", valid, nil},
+		{"expired within leeway", sign(claims(now.Add(-time.Minute), time.Minute-leeway/2), "k1", private), nil},
+		{"expired", sign(claims(now.Add(-time.Hour), time.Minute), "k1", private), ErrExpired},
+		{"issued in the future", sign(claims(now.Add(time.Hour), time.Minute), "k1", private), ErrNotYet},
+		{"signed by another key", sign(claims(now, time.Minute), "k1", other), ErrSignature},
+		{"unknown key", sign(claims(now, time.Minute), "k2", private), ErrUnknownKey},
+		{"tampered claims", parts[0] + "." + encode([]byte(`{"sub":"1","iat":0,"exp":9999999999}`)) + "." + parts[2], ErrSignature},
+		{"algorithm none", encode([]byte(`{"alg":"none","kid":"k1"}`)) + "." + parts[1] + ".", ErrAlgorithm},
+		{"two parts", parts[0] + "." + parts[1], ErrMalformed},
+		{"bad header", "!." + parts[1] + "." + parts[2], ErrMalformed},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.token, keys)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Subject != "42" || !got.HasScope("users.read")) {
+				t.Errorf("Parse() = %+v, want the signed claims", got)
+			}
+		})
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	public, _ := newTestKey(t)
+
+	got, err := NewJWK("k1", public).PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(public) {
+		t.Error("PublicKey() differs from the key the JWK was made of")
+	}
+
+	bad := []JWK{
+		{KeyType: "RSA", Curve: "Ed25519", X: encode(public)},
+		{KeyType: "OKP", Curve: "X25519", X: encode(public)},
+		{KeyType: "OKP", Curve: "Ed25519", X: encode(public[:16])},
+		{KeyType: "OKP", Curve: "Ed25519", X: "!" + base64.RawURLEncoding.EncodeToString(public)},
+	}
+	for _, jwk := range bad {
+		if _, err := jwk.PublicKey(); err == nil {
+			t.Errorf("PublicKey() of %+v succeeded", jwk)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"user-service/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Permissions granted through roles, guarding the admin endpoints.
+const (
+	permissionUsersRead   = "users:read"
+	permissionUsersDelete = "users:delete"
+	permissionRolesManage = "roles:manage"
+	permissionMFAReset    = "mfa:reset"
+)
+
+// adminKeyActor stands in the audit log for changes made with the admin API key.
+const adminKeyActor = "admin api key"
+
+// ListUsers returns every user.
+func (app *Config) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.Models.User.GetAll(r.Context())
+	if err != nil {
+		log.Printf("error while listing users, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to list users"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	for _, user := range users {
+		user.Password = ""
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "users",
+		Data:    users,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// DeleteUser deletes a user and ends their sessions.
+func (app *Config) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Models.User.Delete(r.Context(), user.ID); err != nil {
+		log.Printf("error while deleting user, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to delete user"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.endAllSessions(r.Context(), user.Email)
+	log.Printf("[User=%s] deleted by %s", user.Email, app.actor(r))
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("user %s deleted", user.Email),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ListRoles returns every role with its permissions.
+func (app *Config) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.Models.Role.GetAll(r.Context())
+	if err != nil {
+		log.Printf("error while listing roles, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to list roles"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "roles",
+		Data:    roles,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// UserRoles returns the roles and permissions of a user together with the
+// audit trail of their role changes.
+func (app *Config) UserRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles, permissions, err := app.Models.Role.GetForUser(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error while loading roles, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to load roles"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	audit, err := app.Models.Role.AuditLog(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error while loading role audit log, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to load roles"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("roles of user %s", user.Email),
+		Data: map[string]any{
+			"roles":       roles,
+			"permissions": permissions,
+			"audit":       audit,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// GrantRole gives a role to a user.
+func (app *Config) GrantRole(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Role string `json:"role" validate:"required"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.Models.Role.Grant(r.Context(), app.actor(r), user.ID, requestPayload.Role)
+	app.roleChanged(w, r, user, requestPayload.Role, err, "granted")
+}
+
+// RevokeRole takes a role away from a user.
+func (app *Config) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	role := chi.URLParam(r, "role")
+	err := app.Models.Role.Revoke(r.Context(), app.actor(r), user.ID, role)
+	app.roleChanged(w, r, user, role, err, "revoked")
+}
+
+// roleChanged answers a grant or revoke. A user's roles travel inside their
+// access tokens, so their sessions are ended to have them log in again with
+// the new ones.
+func (app *Config) roleChanged(w http.ResponseWriter, r *http.Request, user *data.User, role string, err error, verb string) {
+	switch {
+	case errors.Is(err, data.ErrRoleNotFound):
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	case errors.Is(err, data.ErrRoleAlreadyGranted), errors.Is(err, data.ErrRoleNotGranted):
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("error while changing roles, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to change roles"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.endAllSessions(r.Context(), user.Email)
+	log.Printf("[User=%s] role %s %s by %s", user.Email, role, verb, app.actor(r))
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("role %s %s for user %s", role, verb, user.Email),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// targetUser returns the user of the id in the path, answering the request
+// itself when there is none.
+func (app *Config) targetUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := app.Models.User.Get(r.Context(), id)
+	if err != nil {
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// actor names who made an admin request, for the logs and the audit trail.
+func (app *Config) actor(r *http.Request) string {
+	if r.Header.Get("X-Admin-Key") != "" {
+		return adminKeyActor
+	}
+
+	principal, err := app.principal(r)
+	if err != nil {
+		return adminKeyActor
+	}
+
+	return principal.Email
+}
+
+// endAllSessions revokes every session of email. A failure is only logged, the
+// sessions still expire on their own.
+func (app *Config) endAllSessions(ctx context.Context, email string) {
+	_, err := app.Auth.RevokeAllSessions(ctx, email, "")
+	if err != nil {
+		log.Printf("[User=%s] error while revoking sessions, %s", email, err)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"authentication-service/authclient"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"time"
 	"user-service/data"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// errInvalidCredentials answers a wrong password and an unknown email alike.
+var errInvalidCredentials = errors.New("invalid credentials")
+
 func (app *Config) Signup(w http.ResponseWriter, r *http.Request) {
 	var requestPayload data.User
 	err := app.readJSON(w, r, &requestPayload)
@@ -18,16 +23,30 @@ func (app *Config) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.Models.User.Insert(requestPayload)
+	// accounts only become active once their email is verified
+	requestPayload.Status = data.UserStatusPending
+
+	user, err := app.Models.User.Insert(r.Context(), requestPayload)
+	if errors.Is(err, data.ErrDuplicateEmail) {
+		app.errorCodeJSON(w, err, errorCodeDuplicateEmail, http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Print(err)
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
+	requestPayload.ID = user
+	requestPayload.Email = data.NormalizeEmail(requestPayload.Email)
+	if err = app.sendVerification(r.Context(), &requestPayload); err != nil {
+		// the user can ask for another email
+		log.Printf("error while sending verification email, %s", err)
+	}
+
 	payload := JsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("User created"),
+		Message: "User created, check your email to verify it",
 		Data:    user,
 	}
 
@@ -38,6 +57,7 @@ func (app *Config) Login(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email    string `json:"email" validate:"required,email"`
 		Password string `json:"password" validate:"required"`
+		Device   string `json:"device"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -47,10 +67,30 @@ func (app *Config) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	requestPayload.Email = data.NormalizeEmail(requestPayload.Email)
+
+	ip := clientIP(r)
+	err = app.Auth.ReportLogin(r.Context(), authclient.LoginAttempted, requestPayload.Email, ip)
+	if err != nil {
+		if !app.tooManyAttempts(w, err) {
+			log.Printf("error from authentication service, %s", err)
+			app.errorJSON(w, errors.New("unable to log in, try again later"), http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	user, err := app.Models.User.GetByEmail(r.Context(), requestPayload.Email)
 	if err != nil {
 		log.Print(err)
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
+		// a query that never ran says nothing about the credentials
+		if app.contextError(w, err) {
+			return
+		}
+		// unknown emails count too, so they cannot be told apart by the lockout
+		if err := app.Auth.ReportLogin(r.Context(), authclient.LoginFailed, requestPayload.Email, ip); app.tooManyAttempts(w, err) {
+			return
+		}
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
@@ -60,14 +100,49 @@ func (app *Config) Login(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error while matching password. %v", err)
 		}
 
-		app.errorJSON(w, errors.New("email or Password doesnt match"), http.StatusBadRequest)
+		if err := app.Auth.ReportLogin(r.Context(), authclient.LoginFailed, requestPayload.Email, ip); app.tooManyAttempts(w, err) {
+			return
+		}
+		// answered like an unknown email, so the two cannot be told apart
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	if user.Status == data.UserStatusPending {
+		app.errorJSON(w, errEmailNotVerified, http.StatusForbidden)
 		return
 	}
 
-	tokenResponse, err := app.GenerateToken(requestPayload.Email)
+	mfaEnabled, err := app.Models.MFA.IsEnabled(r.Context(), user.ID)
 	if err != nil {
+		log.Printf("error while checking two-factor authentication, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to log in, try again later"), http.StatusInternalServerError)
+		}
+		return
+	}
+	if mfaEnabled {
+		// failed logins are only forgotten once the second factor matched too
+		app.startMFAChallenge(w, r, user, requestPayload.Device)
+		return
+	}
+
+	if err := app.Auth.ReportLogin(r.Context(), authclient.LoginSucceeded, requestPayload.Email, ip); err != nil {
 		log.Printf("error from authentication service, %s", err)
-		app.errorJSON(w, err, http.StatusForbidden)
+	}
+
+	app.startSession(w, r, user, requestPayload.Device)
+}
+
+// startSession opens a session for user, who proved who they are, and sets
+// its cookies.
+func (app *Config) startSession(w http.ResponseWriter, r *http.Request, user *data.User, device string) {
+	tokenResponse, err := app.GenerateToken(r.Context(), user, device, clientIP(r), r.UserAgent())
+	if err != nil {
+		log.Printf("error while opening session, %s", err)
+		if !app.contextError(w, err) && !app.authUnavailable(w, err) {
+			app.errorJSON(w, errors.New("unable to log in, try again later"), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -77,37 +152,78 @@ func (app *Config) Login(w http.ResponseWriter, r *http.Request) {
 		Data:    tokenResponse,
 	}
 
-	app.addCookies(
-		w,
-		&http.Cookie{Name: "email", Value: requestPayload.Email, Expires: time.Now().Add(24 * time.Hour), HttpOnly: true},
-		&http.Cookie{Name: "Authorization", Value: tokenResponse, Expires: time.Now().Add(24 * time.Hour), HttpOnly: true},
-	)
+	if err = app.setSessionCookies(w, tokenResponse); err != nil {
+		log.Printf("error while setting session cookies, %s", err)
+		app.errorJSON(w, errors.New("unable to log in, try again later"), http.StatusInternalServerError)
+		return
+	}
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) UserProfile(w http.ResponseWriter, r *http.Request) {
+// RefreshToken exchanges the refresh token, sent as a cookie or in the body,
+// for a new access token and a new refresh token.
+func (app *Config) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Email string `json:"email"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
-	err := app.readJSON(w, r, &requestPayload)
+	refreshToken, err := app.readCookie(r, refreshTokenCookie)
 	if err != nil {
-		log.Println(err)
-		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		app.clearSessionCookies(w)
+		app.errorJSON(w, err, http.StatusUnauthorized)
 		return
 	}
 
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
-	if err != nil {
-		payload := JsonResponse{
-			Error:   true,
-			Message: "user not found",
-			Data:    map[string]string{},
+	if refreshToken != "" {
+		if err = app.checkCSRF(r); err != nil {
+			app.errorJSON(w, err, http.StatusForbidden)
+			return
 		}
-		app.writeJSON(w, http.StatusNotFound, payload)
+		requestPayload.RefreshToken = refreshToken
+	} else {
+		err = app.readJSON(w, r, &requestPayload)
+		if err != nil {
+			log.Print(err)
+			app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tokenResponse, err := app.Auth.RefreshToken(r.Context(), requestPayload.RefreshToken)
+	if err != nil && app.authUnavailable(w, err) {
+		log.Printf("error from authentication service, %s", err)
+		return
+	}
+	if err != nil {
+		log.Printf("error from authentication service, %s", err)
+		app.clearSessionCookies(w)
+		app.errorJSON(w, errors.New("invalid session"), http.StatusUnauthorized)
 		return
 	}
 
+	payload := JsonResponse{
+		Error:   false,
+		Message: "token refreshed successfully",
+		Data:    tokenResponse,
+	}
+
+	if err = app.setSessionCookies(w, tokenResponse); err != nil {
+		log.Printf("error while setting session cookies, %s", err)
+		app.errorJSON(w, errors.New("unable to refresh token"), http.StatusInternalServerError)
+		return
+	}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// UserProfile returns the profile of the logged in user.
+func (app *Config) UserProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.currentUserError(w, err)
+		return
+	}
+	user.Password = ""
+
 	payload := JsonResponse{
 		Error:   false,
 		Message: "user profile fetched successfully",
@@ -117,9 +233,12 @@ func (app *Config) UserProfile(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
+// UpdateProfile changes the names of the logged in user. Fields left out keep
+// their value.
+func (app *Config) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Email string `json:"email"`
+		FirstName *string `json:"first_name" validate:"omitempty,min=1,max=255"`
+		LastName  *string `json:"last_name" validate:"omitempty,min=1,max=255"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -129,10 +248,55 @@ func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Logging out user %s", requestPayload.Email)
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.currentUserError(w, err)
+		return
+	}
+
+	if requestPayload.FirstName != nil {
+		user.FirstName = *requestPayload.FirstName
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = *requestPayload.LastName
+	}
 
-	if err = app.revokeSession(requestPayload.Email); err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+	if err = app.Models.User.Update(r.Context(), user); err != nil {
+		log.Printf("error while updating user, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to update profile"), http.StatusInternalServerError)
+		}
+		return
+	}
+	user.Password = ""
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "user profile updated successfully",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Logout ends the session the request was made with.
+func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, err := app.principal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+	if principal.SessionID == "" {
+		app.errorJSON(w, errors.New("no session to log out of"), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Logging out user %s", principal.Email)
+
+	if err = app.revokeSession(r.Context(), principal.Email, principal.SessionID); err != nil {
+		if !app.authUnavailable(w, err) {
+			app.errorJSON(w, errors.New("unable to revoke session"), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -142,11 +306,86 @@ func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
 		Data:    map[string]string{},
 	}
 
-	app.addCookies(
-		w,
-		&http.Cookie{Name: "email", Value: "", Expires: time.Now().Add(-1), HttpOnly: true},
-		&http.Cookie{Name: "Authorization", Value: "", Expires: time.Now().Add(-1), HttpOnly: true},
-	)
+	app.clearSessionCookies(w)
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ListSessions returns the sessions of the logged in user, flagging the one
+// the request was made with.
+func (app *Config) ListSessions(w http.ResponseWriter, r *http.Request) {
+	principal, err := app.principal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := app.Auth.ListSessions(r.Context(), principal.Email, principal.SessionID)
+	if err != nil {
+		log.Printf("error from authentication service, %s", err)
+		if !app.authUnavailable(w, err) {
+			app.errorJSON(w, errors.New("unable to list sessions"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "sessions fetched successfully",
+		Data:    sessions,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// RevokeSession ends one of the sessions of the logged in user.
+func (app *Config) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	principal, err := app.principal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.revokeSession(r.Context(), principal.Email, chi.URLParam(r, "id"))
+	if err != nil {
+		if !app.authUnavailable(w, err) {
+			app.errorJSON(w, errors.New("session not found"), http.StatusNotFound)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "session revoked successfully",
+		Data:    map[string]string{},
+	}
+
 	app.writeJSON(w, http.StatusAccepted, payload)
+}
 
+// RevokeOtherSessions ends every session of the logged in user except the one
+// the request was made with.
+func (app *Config) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	// without a session of its own a token would end every session
+	principal, err := app.sessionPrincipal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	revoked, err := app.Auth.RevokeAllSessions(r.Context(), principal.Email, principal.SessionID)
+	if err != nil {
+		log.Printf("error from authentication service, %s", err)
+		if !app.authUnavailable(w, err) {
+			app.errorJSON(w, errors.New("unable to revoke sessions"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "other sessions revoked successfully",
+		Data:    map[string]int{"revoked": revoked},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
 }
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"authentication-service/authclient"
+	"authentication-service/jwt"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user-service/config"
+	"user-service/data"
+	"user-service/securecookie"
+)
+
+const (
+	testKeyID      = "test"
+	testAdminKey   = "test-admin-key"
+	testPassword   = "correct horse battery"
+	lockedOutEmail = "locked@example.com"
+	testSessionID  = "session-1"
+	// endedSessionID is a session the fake authentication service ended.
+	endedSessionID = "session-ended"
+	testClientIP   = "192.0.2.1"
+)
+
+// testApp is the service backed by the in-memory repositories and a fake
+// authentication service, which publishes the key access tokens are signed
+// with, opens sessions, knows endedSessionID ended and locks lockedOutEmail
+// out.
+type testApp struct {
+	app     *Config
+	handler http.Handler
+	users   *data.MemoryUserRepository
+	mfa     *data.MemoryMFARepository
+	key     ed25519.PrivateKey
+	// revoked counts the calls to end every session of a user.
+	revoked atomic.Int32
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := &testApp{users: data.NewMemoryUserRepository(), mfa: data.NewMemoryMFARepository(), key: private}
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Email string `json:"email"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		reply := map[string]any{"error": false, "message": "ok", "data": map[string]any{}}
+		status := http.StatusAccepted
+		switch {
+		case r.URL.Path == "/jwks":
+			json.NewEncoder(w).Encode(jwt.JWKS{Keys: []jwt.JWK{jwt.NewJWK(testKeyID, public)}})
+			return
+		case strings.HasPrefix(r.URL.Path, "/login/") && body.Email == lockedOutEmail:
+			w.Header().Set("Retry-After", "60")
+			reply = map[string]any{"error": true, "message": "too many login attempts"}
+			status = http.StatusTooManyRequests
+		case r.Method == http.MethodPost && r.URL.Path == "/sessions":
+			reply["data"] = authclient.Tokens{
+				Email:            body.Email,
+				SessionID:        testSessionID,
+				AccessToken:      "access-token",
+				TokenType:        "Bearer",
+				ExpiresIn:        900,
+				RefreshToken:     "refresh-token",
+				RefreshExpiresIn: 86400,
+			}
+		case r.Method == http.MethodGet && r.URL.Path == "/sessions/"+endedSessionID:
+			reply = map[string]any{"error": true, "message": "session not found"}
+			status = http.StatusNotFound
+		case r.Method == http.MethodDelete && r.URL.Path == "/sessions":
+			ta.revoked.Add(1)
+			reply["data"] = map[string]int{"revoked": 1}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(reply)
+	}))
+	t.Cleanup(auth.Close)
+
+	cookies, err := securecookie.New("test-signing-key-of-32-bytes-at-least", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := config.Default()
+	settings.AdminAPIKey = testAdminKey
+
+	ta.app = &Config{
+		Settings: settings,
+		Models: data.Models{
+			User:                ta.users,
+			MFA:                 ta.mfa,
+			PersonalAccessToken: data.NewMemoryPersonalAccessTokenRepository(ta.users),
+			Role: data.NewMemoryRoleRepository(
+				data.Role{Name: "admin", Permissions: []string{permissionUsersRead, permissionUsersDelete, permissionRolesManage, permissionMFAReset}},
+				data.Role{Name: "moderator", Permissions: []string{permissionUsersRead}},
+			),
+			EmailVerification: data.NewMemoryEmailVerificationRepository(ta.users),
+			PasswordReset:     data.NewMemoryPasswordResetRepository(),
+		},
+		Keys:    jwt.NewRemoteKeySet(auth.URL + "/jwks"),
+		Auth:    authclient.New(auth.URL, authclient.Options{Retries: -1}),
+		Cookies: cookies,
+	}
+	ta.handler = ta.app.routes()
+
+	return ta
+}
+
+// addUser stores a user of email with testPassword and returns its id.
+func (ta *testApp) addUser(t *testing.T, email string, status string) int {
+	t.Helper()
+
+	id, err := ta.users.Insert(context.Background(), data.User{
+		Email:     email,
+		FirstName: "Ada",
+		LastName:  "Lovelace",
+		Password:  testPassword,
+		Status:    status,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// accessToken returns an access token of the user id, tied to sessionID when
+// not empty.
+func (ta *testApp) accessToken(t *testing.T, id int, email string, sessionID string) string {
+	t.Helper()
+
+	now := time.Now()
+	token, err := jwt.Sign(&jwt.Claims{
+		Subject:   strconv.Itoa(id),
+		Email:     email,
+		Scope:     scopeUsersRead + " " + scopeUsersWrite,
+		SessionID: sessionID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+	}, testKeyID, ta.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// serve sends a request with body encoded as JSON, and the headers given as
+// name, value pairs, and returns the recorded answer.
+func (ta *testApp) serve(method string, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+
+	r := httptest.NewRequest(method, path, &buf)
+	r.RemoteAddr = testClientIP + ":1234"
+	r.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	ta.handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestLogin(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	ta.addUser(t, "pending@example.com", data.UserStatusPending)
+	ta.addUser(t, lockedOutEmail, data.UserStatusActive)
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		want     int
+	}{
+		{"unknown email", "nobody@example.com", testPassword, http.StatusUnauthorized},
+		{"wrong password", "ada@example.com", "wrong password", http.StatusUnauthorized},
+		{"email in another case", "ADA@example.com", testPassword, http.StatusAccepted},
+		{"email not verified", "pending@example.com", testPassword, http.StatusForbidden},
+		{"locked out", lockedOutEmail, testPassword, http.StatusTooManyRequests},
+		{"invalid email", "ada", testPassword, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ta.serve(http.MethodPost, "/user/login", map[string]string{"email": tt.email, "password": tt.password})
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d, body %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// telling the two apart would reveal who has an account
+	unknown := ta.serve(http.MethodPost, "/user/login", map[string]string{"email": "nobody@example.com", "password": testPassword})
+	wrong := ta.serve(http.MethodPost, "/user/login", map[string]string{"email": "ada@example.com", "password": "wrong password"})
+	if unknown.Code != wrong.Code || unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("unknown email answered %d %s, wrong password %d %s", unknown.Code, unknown.Body, wrong.Code, wrong.Body)
+	}
+}
+
+func TestLoginSession(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addUser(t, "ada@example.com", data.UserStatusActive)
+
+	w := ta.serve(http.MethodPost, "/user/login", map[string]string{"email": "Ada@example.com", "password": testPassword})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	var reply struct {
+		Data authclient.Tokens `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Data.SessionID != testSessionID {
+		t.Errorf("session id = %q, want %q", reply.Data.SessionID, testSessionID)
+	}
+
+	cookies := map[string]bool{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = true
+	}
+	for _, name := range []string{accessTokenCookie, refreshTokenCookie, csrfCookie} {
+		if !cookies[name] {
+			t.Errorf("cookie %s not set", name)
+		}
+	}
+}
+
+func TestLoginMFAChallenge(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+
+	ctx := context.Background()
+	if err := ta.mfa.Enroll(ctx, id, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.mfa.Enable(ctx, id, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ta.serve(http.MethodPost, "/user/login", map[string]string{"email": "ada@example.com", "password": testPassword})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("%d cookies set before the second factor", len(cookies))
+	}
+
+	var reply struct {
+		Data struct {
+			MFARequired bool   `json:"mfa_required"`
+			Challenge   string `json:"challenge"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Data.MFARequired {
+		t.Fatal("mfa_required = false, want true")
+	}
+	if _, err := ta.mfa.GetChallenge(ctx, reply.Data.Challenge); err != nil {
+		t.Errorf("GetChallenge = %v, want the challenge handed out", err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	token := ta.accessToken(t, id, "ada@example.com", testSessionID)
+
+	w := ta.serve(http.MethodPatch, "/me", map[string]string{"first_name": "Augusta"}, "Authorization", "Bearer "+token)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	user, err := ta.users.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FirstName != "Augusta" || user.LastName != "Lovelace" {
+		t.Errorf("names = %q %q, want Augusta Lovelace", user.FirstName, user.LastName)
+	}
+
+	if w := ta.serve(http.MethodGet, "/me", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("status without a token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestUserProfileErrors(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	token := "Bearer " + ta.accessToken(t, id, "ada@example.com", testSessionID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "/me", nil).WithContext(ctx)
+	r.Header.Set("Authorization", token)
+	w := httptest.NewRecorder()
+	ta.handler.ServeHTTP(w, r)
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("status of a cancelled request = %d, want %d, body %s", w.Code, statusClientClosedRequest, w.Body)
+	}
+
+	if err := ta.users.Delete(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if w := ta.serve(http.MethodGet, "/me", nil, "Authorization", token); w.Code != http.StatusNotFound {
+		t.Errorf("status of a deleted user = %d, want %d, body %s", w.Code, http.StatusNotFound, w.Body)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	pat, _, err := ta.app.Models.PersonalAccessToken.Insert(context.Background(), id, "bot", []string{scopeUsersRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		sessionID   string
+		current     string
+		want        int
+		wantChanged bool
+	}{
+		{"token without a session", "", testPassword, http.StatusForbidden, false},
+		{"wrong current password", testSessionID, "wrong password", http.StatusBadRequest, false},
+		{"changed", testSessionID, testPassword, http.StatusAccepted, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := ta.accessToken(t, id, "ada@example.com", tt.sessionID)
+			body := map[string]string{"current_password": tt.current, "new_password": "a new password"}
+			w := ta.serve(http.MethodPut, "/user/password", body, "Authorization", "Bearer "+token)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.want, w.Body)
+			}
+
+			user, err := ta.users.Get(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed, err := user.PasswordMatches("a new password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("password changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+
+	if revoked := ta.revoked.Load(); revoked != 1 {
+		t.Errorf("sessions ended %d times, want once", revoked)
+	}
+	if w := ta.serve(http.MethodGet, "/me", nil, "Authorization", "Bearer "+pat); w.Code != http.StatusUnauthorized {
+		t.Errorf("status with a personal access token after the change = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestEndedSession(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	token := "Bearer " + ta.accessToken(t, id, "ada@example.com", endedSessionID)
+
+	body := map[string]string{"current_password": testPassword, "new_password": "a new password"}
+	if w := ta.serve(http.MethodPut, "/user/password", body, "Authorization", token); w.Code != http.StatusUnauthorized {
+		t.Errorf("status changing the password = %d, want %d, body %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+	if w := ta.serve(http.MethodPost, "/user/tokens", map[string]any{"name": "bot", "scopes": []string{scopeUsersRead}}, "Authorization", token); w.Code != http.StatusUnauthorized {
+		t.Errorf("status creating a token = %d, want %d, body %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+
+	// other routes trust the token until it expires
+	if w := ta.serve(http.MethodGet, "/me", nil, "Authorization", token); w.Code != http.StatusAccepted {
+		t.Errorf("status reading the profile = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	ta.addUser(t, "grace@example.com", data.UserStatusActive)
+
+	if w := ta.serve(http.MethodGet, "/admin/users", nil, "X-Admin-Key", "wrong key"); w.Code != http.StatusUnauthorized {
+		t.Errorf("status with a wrong key = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w := ta.serve(http.MethodGet, "/admin/users", nil, "X-Admin-Key", testAdminKey)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	var listed struct {
+		Data []data.User `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Data) != 2 {
+		t.Fatalf("listed %d users, want 2", len(listed.Data))
+	}
+	for _, user := range listed.Data {
+		if user.Password != "" {
+			t.Errorf("password of %s listed", user.Email)
+		}
+	}
+
+	w = ta.serve(http.MethodDelete, "/admin/users/"+strconv.Itoa(id), nil, "X-Admin-Key", testAdminKey)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	if _, err := ta.users.Get(context.Background(), id); err != data.ErrUserNotFound {
+		t.Errorf("Get after delete = %v, want %v", err, data.ErrUserNotFound)
+	}
+	if w := ta.serve(http.MethodDelete, "/admin/users/"+strconv.Itoa(id), nil, "X-Admin-Key", testAdminKey); w.Code != http.StatusNotFound {
+		t.Errorf("status deleting again = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	path := "/admin/users/" + strconv.Itoa(id) + "/roles"
+
+	tests := []struct {
+		name string
+		role string
+		want int
+	}{
+		{"granted", "moderator", http.StatusAccepted},
+		{"granted again", "moderator", http.StatusConflict},
+		{"unknown role", "owner", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ta.serve(http.MethodPost, path, map[string]string{"role": tt.role}, "X-Admin-Key", testAdminKey)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d, body %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	w := ta.serve(http.MethodGet, path, nil, "X-Admin-Key", testAdminKey)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	var listed struct {
+		Data struct {
+			Roles       []string          `json:"roles"`
+			Permissions []string          `json:"permissions"`
+			Audit       []*data.RoleAudit `json:"audit"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Data.Roles) != 1 || listed.Data.Roles[0] != "moderator" {
+		t.Errorf("roles = %v, want [moderator]", listed.Data.Roles)
+	}
+	if len(listed.Data.Permissions) != 1 || listed.Data.Permissions[0] != permissionUsersRead {
+		t.Errorf("permissions = %v, want [%s]", listed.Data.Permissions, permissionUsersRead)
+	}
+	if len(listed.Data.Audit) != 1 || listed.Data.Audit[0].Action != data.RoleGranted {
+		t.Errorf("audit log has %d entries, want the one grant", len(listed.Data.Audit))
+	}
+
+	if revoked := ta.revoked.Load(); revoked != 1 {
+		t.Errorf("sessions ended %d times, want once", revoked)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+
+	tests := []struct {
+		name      string
+		sessionID string
+		want      int
+	}{
+		{"token without a session", "", http.StatusForbidden},
+		{"login session", testSessionID, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := ta.accessToken(t, id, "ada@example.com", tt.sessionID)
+			w := ta.serve(http.MethodDelete, "/user/sessions", nil, "Authorization", "Bearer "+token)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d, body %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	if revoked := ta.revoked.Load(); revoked != 1 {
+		t.Errorf("sessions ended %d times, want once", revoked)
+	}
+}
@@ -1,19 +1,44 @@
 package main
 
 import (
-	"bytes"
+	"authentication-service/authclient"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
+	"user-service/data"
 )
 
+// Session cookies and the header echoing the CSRF token.
+const (
+	accessTokenCookie  = "Authorization"
+	refreshTokenCookie = "refresh_token"
+	refreshTokenPath   = "/user/token/refresh"
+	csrfCookie         = "csrf_token"
+	csrfHeader         = "X-CSRF-Token"
+)
+
+// Error codes telling clients apart errors they handle, in the code field of
+// error responses.
+const (
+	errorCodeDuplicateEmail = "duplicate_email"
+)
+
+var errInvalidCSRF = errors.New("missing or invalid CSRF token")
+
 type JsonResponse struct {
 	Error   bool   `json:"error"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
@@ -113,121 +138,176 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 	return app.writeJSON(w, statusCode, payload)
 }
 
+// errorCodeJSON answers with err like errorJSON, along with the error code of
+// err for clients to act on.
+func (app *Config) errorCodeJSON(w http.ResponseWriter, err error, code string, status int) error {
+	payload := JsonResponse{
+		Error:   true,
+		Code:    code,
+		Message: err.Error(),
+	}
+
+	return app.writeJSON(w, status, payload)
+}
+
 func (app *Config) addCookies(w http.ResponseWriter, cookies ...*http.Cookie) {
 	for _, cookie := range cookies {
 		http.SetCookie(w, cookie)
 	}
 }
 
-func (app *Config) validateToken(email string, token string) (any, error) {
-	requestPaylod := AuthRequest{
-		Email: email,
-		Token: token,
+// setSessionCookies stores the tokens of a login or a refresh in signed
+// cookies, along with a fresh CSRF token. The refresh token is only sent back
+// to the refresh endpoint.
+func (app *Config) setSessionCookies(w http.ResponseWriter, tokens *authclient.Tokens) error {
+	accessExpiry := time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	refreshExpiry := time.Now().Add(time.Duration(tokens.RefreshExpiresIn) * time.Second)
+
+	csrfToken := make([]byte, 32)
+	if _, err := rand.Read(csrfToken); err != nil {
+		return err
 	}
-	jsonData, _ := json.MarshalIndent(requestPaylod, "", "\t")
 
-	request, err := http.NewRequest("POST", "http://authentication-service/authenticate", bytes.NewBuffer(jsonData))
+	access, err := app.Cookies.Encode(accessTokenCookie, tokens.AccessToken)
 	if err != nil {
-		log.Printf("Error while creating auth request %s", err)
-		return nil, err
+		return err
 	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
+	refresh, err := app.Cookies.Encode(refreshTokenCookie, tokens.RefreshToken)
 	if err != nil {
-		log.Printf("Got error from auth service %s", err)
-		return nil, err
+		return err
 	}
-
-	if response.StatusCode == http.StatusUnauthorized {
-		log.Println("Got unauthorized error from auth service")
-		return nil, errors.New("invalid session")
+	csrf, err := app.Cookies.Encode(csrfCookie, base64.RawURLEncoding.EncodeToString(csrfToken))
+	if err != nil {
+		return err
 	}
 
-	var session JsonResponse
+	app.addCookies(
+		w,
+		app.cookie(accessTokenCookie, access, "/", accessExpiry, true),
+		app.cookie(refreshTokenCookie, refresh, refreshTokenPath, refreshExpiry, true),
+		// scripts read the CSRF token to send it back in a header
+		app.cookie(csrfCookie, csrf, "/", refreshExpiry, false),
+	)
 
-	return session, nil
+	return nil
 }
 
-func (app *Config) GenerateToken(email string) (string, error) {
+// clearSessionCookies expires every session cookie, including the email cookie
+// set by earlier versions.
+func (app *Config) clearSessionCookies(w http.ResponseWriter) {
+	expired := time.Now().Add(-time.Hour)
+
+	app.addCookies(
+		w,
+		app.cookie("email", "", "", expired, true),
+		app.cookie(accessTokenCookie, "", "/", expired, true),
+		app.cookie(refreshTokenCookie, "", refreshTokenPath, expired, true),
+		app.cookie(csrfCookie, "", "/", expired, false),
+	)
+}
 
-	var requestPayload = AuthRequest{
-		Email: email,
+// cookie returns a cookie with the attributes set in the configuration.
+func (app *Config) cookie(name string, value string, path string, expires time.Time, httpOnly bool) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch app.Settings.Cookies.SameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
 	}
 
-	jsonData, _ := json.MarshalIndent(requestPayload, "", "\t")
-
-	request, err := http.NewRequest("GET", "http://authentication-service/token", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Error while creating auth request %s", err)
-		return "payload", err
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   app.Settings.Cookies.Domain,
+		Expires:  expires,
+		Secure:   app.Settings.Cookies.Secure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
 	}
+}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+// readCookie returns the verified value of the signed cookie name, or an
+// empty string when the request has no such cookie.
+func (app *Config) readCookie(r *http.Request, name string) (string, error) {
+	cookie, err := r.Cookie(name)
 	if err != nil {
-		log.Printf("Got error from auth service %s", err)
-		return "", err
+		return "", nil
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusAccepted {
-		log.Println("Got error from auth service")
+	value, err := app.Cookies.Decode(name, cookie.Value)
+	if err != nil {
+		log.Printf("cookie %s failed verification", name)
 		return "", errors.New("invalid session")
 	}
 
-	// Read the response body
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		fmt.Println("Error reading the response body:", err)
-		return "", errors.New("error reading response body")
-	}
+	return value, nil
+}
 
-	var responsePayload JsonResponse
-	err = json.Unmarshal(body, &responsePayload)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON:", err)
-		return "", err
+// checkCSRF verifies the double submitted CSRF token of a request
+// authenticated by cookies: the header has to repeat the CSRF cookie, which
+// only scripts of the site can read, and the cookie has to be one we signed.
+func (app *Config) checkCSRF(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
 	}
 
-	authResponse, ok := responsePayload.Data.(map[string]any)
-	if !ok {
-		return "", fmt.Errorf("data is not a map[string]interface{}")
+	cookie, err := r.Cookie(csrfCookie)
+	header := r.Header.Get(csrfHeader)
+	if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return errInvalidCSRF
 	}
-
-	result := make(map[string]string)
-	for key, value := range authResponse {
-		// Perform a type assertion to convert value to string
-		strValue, ok := value.(string)
-		if !ok {
-			return "", fmt.Errorf("value for key '%s' is not a string", key)
-		}
-		result[key] = strValue
+	if _, err := app.Cookies.Decode(csrfCookie, cookie.Value); err != nil {
+		return errInvalidCSRF
 	}
 
-	return result["token"], nil
+	return nil
 }
 
-func (app *Config) revokeSession(email string) error {
-	var requestPayload = AuthRequest{
-		Email: email,
+// tokenLink returns the link to base carrying token, signed for name, in its
+// token query parameter.
+func (app *Config) tokenLink(base string, name string, token string) (string, error) {
+	signed, err := app.Cookies.Encode(name, token)
+	if err != nil {
+		return "", err
 	}
 
-	jsonRequestData, _ := json.MarshalIndent(requestPayload, "", "\t")
-	request, err := http.NewRequest("DELETE", "http://authentication-service/revoke", bytes.NewBuffer(jsonRequestData))
+	link, err := url.Parse(base)
 	if err != nil {
-		log.Printf("error in making request, %s", err)
-		return err
+		return "", err
 	}
+	query := link.Query()
+	query.Set("token", signed)
+	link.RawQuery = query.Encode()
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	return link.String(), nil
+}
+
+// GenerateToken opens a session for user, carrying their roles, and returns
+// its tokens.
+func (app *Config) GenerateToken(ctx context.Context, user *data.User, device string, ip string, userAgent string) (*authclient.Tokens, error) {
+	roles, permissions, err := app.Models.Role.GetForUser(ctx, user.ID)
 	if err != nil {
-		log.Printf("error while sending request, %s", err)
-		return err
+		log.Printf("error while loading roles, %s", err)
+		return nil, err
 	}
 
-	if response.StatusCode != http.StatusAccepted {
+	return app.Auth.CreateSession(ctx, authclient.SessionRequest{
+		UserID:      user.ID,
+		Email:       user.Email,
+		Device:      device,
+		IP:          ip,
+		UserAgent:   userAgent,
+		Roles:       roles,
+		Permissions: permissions,
+	})
+}
+
+func (app *Config) revokeSession(ctx context.Context, email string, sessionID string) error {
+	err := app.Auth.RevokeSession(ctx, email, sessionID)
+	if err != nil {
 		log.Printf("Got error from auth service, %s", err)
 		return err
 	}
@@ -235,3 +315,64 @@ func (app *Config) revokeSession(email string) error {
 	log.Printf("[User=%s] Session revoked. Bye Bye !!", email)
 	return nil
 }
+
+// tooManyAttempts answers with the 429 of the authentication service when err
+// is one, forwarding its rate limit headers, and reports whether it did.
+func (app *Config) tooManyAttempts(w http.ResponseWriter, err error) bool {
+	var authErr *authclient.Error
+	if !errors.As(err, &authErr) || !errors.Is(err, authclient.ErrTooManyRequests) {
+		return false
+	}
+
+	for _, key := range []string{"Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+		if value := authErr.Header.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	app.errorJSON(w, authErr, http.StatusTooManyRequests)
+
+	return true
+}
+
+// authUnavailable answers with 503 when err comes from the authentication
+// service being down, and reports whether it did.
+func (app *Config) authUnavailable(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, authclient.ErrUnavailable) {
+		return false
+	}
+
+	app.errorJSON(w, errors.New("authentication service unavailable, try again later"), http.StatusServiceUnavailable)
+
+	return true
+}
+
+// statusClientClosedRequest is the nginx status for a request whose client
+// went away before it was answered. Nobody reads the answer, but it tells
+// those requests apart in the logs and metrics.
+const statusClientClosedRequest = 499
+
+// contextError answers with 499 when err comes from the request being
+// cancelled, by its client leaving or the server shutting down, and with 504
+// when a query ran past its timeout, and reports whether it did.
+func (app *Config) contextError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled):
+		app.errorJSON(w, errors.New("request cancelled"), statusClientClosedRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		app.errorJSON(w, errors.New("database timed out, try again later"), http.StatusGatewayTimeout)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// clientIP returns the address of the client that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
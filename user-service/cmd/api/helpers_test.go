@@ -0,0 +1,85 @@
+package main
+
+import (
+	"authentication-service/authclient"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionCookiesExpiry(t *testing.T) {
+	ta := newTestApp(t)
+
+	w := httptest.NewRecorder()
+	err := ta.app.setSessionCookies(w, &authclient.Tokens{AccessToken: "access", ExpiresIn: 900, RefreshToken: "refresh", RefreshExpiresIn: 86400})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	wantExpiry := map[string]time.Duration{
+		accessTokenCookie:  15 * time.Minute,
+		refreshTokenCookie: 24 * time.Hour,
+		csrfCookie:         24 * time.Hour,
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != len(wantExpiry) {
+		t.Fatalf("set %d cookies, want %d", len(cookies), len(wantExpiry))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range cookies {
+		if diff := cookie.Expires.Sub(now.Add(wantExpiry[cookie.Name])); diff < -2*time.Second || diff > 2*time.Second {
+			t.Errorf("%s expires at %s, want in %s", cookie.Name, cookie.Expires, wantExpiry[cookie.Name])
+		}
+		r.AddCookie(cookie)
+	}
+
+	if got, err := ta.app.readCookie(r, accessTokenCookie); err != nil || got != "access" {
+		t.Errorf("readCookie(access) = %q, %v, want the access token", got, err)
+	}
+
+	w = httptest.NewRecorder()
+	ta.app.clearSessionCookies(w)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Value != "" || !cookie.Expires.Before(now) {
+			t.Errorf("cleared %s = %q expiring at %s, want empty and expired", cookie.Name, cookie.Value, cookie.Expires)
+		}
+	}
+}
+
+func TestReadCookieTampered(t *testing.T) {
+	ta := newTestApp(t)
+
+	access, err := ta.app.Cookies.Encode(accessTokenCookie, "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cookie  *http.Cookie
+		want    string
+		wantErr bool
+	}{
+		{"no cookie", nil, "", false},
+		{"signed", &http.Cookie{Name: accessTokenCookie, Value: access}, "access", false},
+		{"unsigned", &http.Cookie{Name: accessTokenCookie, Value: "access"}, "", true},
+		{"tampered", &http.Cookie{Name: accessTokenCookie, Value: "x" + access}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+
+			got, err := ta.app.readCookie(r, accessTokenCookie)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("readCookie() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,67 +1,233 @@
 package main
 
 import (
+	"authentication-service/authclient"
+	"authentication-service/jwt"
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	"user-service/config"
 	"user-service/data"
+	"user-service/mailer"
+	"user-service/securecookie"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
-const webPort = "80"
-
-var counts int64
+// shutdownTimeout is how long requests in flight get to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
 
 type Config struct {
-	DB     *sql.DB
-	Models data.Models
+	Settings *config.Config
+	DB       *pgxpool.Pool
+	Models   data.Models
+	Keys     *jwt.RemoteKeySet
+	Auth     *authclient.Client
+	Cookies  *securecookie.Codec
+	Mailer   mailer.Mailer
 }
 
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	settings, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Error while loading configuration, %s", err)
+	}
+
+	if *printConfig {
+		out, err := settings.Redacted().YAML()
+		if err != nil {
+			log.Fatalf("Error while printing configuration, %s", err)
+		}
+		fmt.Print(out)
+		return
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("Unknown command %s, the only command is migrate", args[0])
+		}
+		if err := runMigrate(settings, args[1:]); err != nil {
+			log.Fatalf("Error while migrating, %s", err)
+		}
+		return
+	}
+
 	log.Println("Starting user service ...")
 
-	conn, err := connectToDB()
+	if settings.AdminAPIKey == "" {
+		log.Println("No admin API key set, admin endpoints are disabled")
+	}
+
+	cookies, err := securecookie.New(settings.Cookies.SigningKey, settings.Cookies.EncryptionKey)
 	if err != nil {
-		log.Println("Can't connect to database")
+		log.Fatalf("Error while setting up cookies, %s", err)
+	}
+
+	// the schema has to be in place before the pool prepares statements on it
+	if settings.Database.MigrateOnStart {
+		if err := migrateOnStart(settings.Database.DSN); err != nil {
+			log.Fatalf("Error while migrating, %s", err)
+		}
+	}
+
+	pool, err := connectToPool(settings.Database)
+	if err != nil {
+		log.Fatalf("Can't connect to database, %s", err)
 	}
 
 	app := Config{
-		DB:     conn,
-		Models: data.New(conn),
+		Settings: settings,
+		DB:       pool,
+		Models:   data.New(pool, settings.Database.QueryTimeout),
+		Keys:     jwt.NewRemoteKeySet(settings.Auth.JWKSURL),
+		Auth:     newAuthClient(settings.Auth),
+		Cookies:  cookies,
+		Mailer:   newMailer(settings.Mail),
 	}
 
+	// requests run under baseCtx, cancelled when the shutdown grace period
+	// runs out so that the queries still in flight give up
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+
 	srv := http.Server{
-		Addr:    fmt.Sprintf(":%s", webPort),
-		Handler: app.routes(),
+		Addr:        fmt.Sprintf(":%s", settings.WebPort),
+		Handler:     app.routes(),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
 		log.Panicln(err)
+	case <-stop:
+	}
+
+	log.Println("Shutting down user service")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("error while shutting down, %s", err)
+	}
+	cancelRequests()
+
+	// waits for the connections held by requests cancelled above
+	if pool != nil {
+		pool.Close()
+	}
+}
+
+func newAuthClient(settings config.AuthConfig) *authclient.Client {
+	// zero picks the client defaults, negative values turn the feature off
+	retries := settings.Retries
+	if retries == 0 {
+		retries = -1
+	}
+	threshold := settings.BreakerThreshold
+	if threshold == 0 {
+		threshold = -1
+	}
+
+	return authclient.New(settings.URL, authclient.Options{
+		Timeout:          settings.Timeout,
+		Retries:          retries,
+		BreakerThreshold: threshold,
+		BreakerCooldown:  settings.BreakerCooldown,
+		RevocationTTL:    settings.AccessTokenTTL,
+		ServiceKey:       settings.ServiceKey,
+	})
+}
+
+func newMailer(settings config.MailConfig) mailer.Mailer {
+	switch settings.Driver {
+	case "smtp":
+		return &mailer.SMTPMailer{
+			Addr:     settings.SMTP.Addr,
+			Username: settings.SMTP.Username,
+			Password: settings.SMTP.Password,
+			From:     settings.From,
+		}
+	case "file":
+		log.Printf("Writing emails to %s instead of sending them", settings.Dir)
+		return &mailer.FileMailer{From: settings.From, Dir: settings.Dir}
+	default:
+		log.Println("Logging emails instead of sending them")
+		return &mailer.LogMailer{From: settings.From}
 	}
 }
 
-func connectToDB() (*sql.DB, error) {
-	dsn := os.Getenv("DSN")
+// connectToDB opens a database/sql handle on dsn, for the migrations.
+func connectToDB(dsn string) (*sql.DB, error) {
+	var db *sql.DB
+	err := waitForDB(func() (err error) {
+		db, err = openDB(dsn)
+		return err
+	})
+
+	return db, err
+}
+
+// connectToPool opens the connection pool the stores run their queries on.
+func connectToPool(settings config.DatabaseConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(settings.DSN)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.MaxConns = int32(settings.MaxConns)
+	poolConfig.MinConns = int32(settings.MinConns)
+	poolConfig.MaxConnLifetime = settings.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = settings.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = settings.HealthCheckPeriod
+	poolConfig.AfterConnect = data.PrepareStatements
+
+	var pool *pgxpool.Pool
+	err = waitForDB(func() (err error) {
+		pool, err = pgxpool.ConnectConfig(context.Background(), poolConfig)
+		return err
+	})
+
+	return pool, err
+}
 
+// waitForDB calls connect until the database answers, backing off in
+// between, and gives up after a few attempts. Each call counts its own
+// attempts.
+func waitForDB(connect func() error) error {
+	var counts int
 	for {
-		connection, err := openDB(dsn)
+		err := connect()
 		if err != nil {
 			log.Println("Database is not yet ready")
 			counts++
 		} else {
 			log.Println("Connected to postgres")
-			return connection, nil
+			return nil
 		}
 
 		if counts > 10 {
 			log.Println(err)
-			return nil, err
+			return err
 		}
 
 		log.Println("Backing off for 2 seconds ..")
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// poolStats is a snapshot of the database connection pool, for monitoring.
+// Counts and durations add up from the start of the service.
+type poolStats struct {
+	MaxConns          int32 `json:"max_conns"`
+	TotalConns        int32 `json:"total_conns"`
+	IdleConns         int32 `json:"idle_conns"`
+	InUseConns        int32 `json:"in_use_conns"`
+	ConstructingConns int32 `json:"constructing_conns"`
+	AcquireCount      int64 `json:"acquire_count"`
+	// WaitedAcquireCount counts the acquires that found no idle connection
+	// and had to wait for one.
+	WaitedAcquireCount   int64 `json:"waited_acquire_count"`
+	CanceledAcquireCount int64 `json:"canceled_acquire_count"`
+	// AcquireWaitSeconds is the time spent acquiring connections, and
+	// AverageAcquireWaitMs that time per acquire.
+	AcquireWaitSeconds      float64 `json:"acquire_wait_seconds"`
+	AverageAcquireWaitMs    float64 `json:"average_acquire_wait_ms"`
+	NewConnsCount           int64   `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64   `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64   `json:"max_idle_destroy_count"`
+}
+
+// DatabaseStats returns the stats of the database connection pool.
+func (app *Config) DatabaseStats(w http.ResponseWriter, r *http.Request) {
+	if app.DB == nil {
+		app.errorJSON(w, errors.New("database unavailable"), http.StatusServiceUnavailable)
+		return
+	}
+
+	stat := app.DB.Stat()
+	stats := poolStats{
+		MaxConns:                stat.MaxConns(),
+		TotalConns:              stat.TotalConns(),
+		IdleConns:               stat.IdleConns(),
+		InUseConns:              stat.AcquiredConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		AcquireCount:            stat.AcquireCount(),
+		WaitedAcquireCount:      stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		AcquireWaitSeconds:      stat.AcquireDuration().Seconds(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+	if stats.AcquireCount > 0 {
+		stats.AverageAcquireWaitMs = stats.AcquireWaitSeconds * 1000 / float64(stats.AcquireCount)
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "database pool stats",
+		Data:    stats,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"authentication-service/authclient"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"user-service/data"
+	"user-service/totp"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxChallengeAttempts is how many wrong codes a login challenge survives.
+const maxChallengeAttempts = 5
+
+var errInvalidCode = errors.New("invalid two-factor code")
+
+// secondFactor is a TOTP code or, when the authenticator is lost, a recovery
+// code.
+type secondFactor struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// EnrollTOTP starts setting up TOTP for the user and returns the secret with
+// its provisioning URI to show as a QR code. It only takes effect once
+// confirmed with ConfirmTOTP.
+func (app *Config) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	// tokens acting for the user must not change how they log in
+	if _, err := app.sessionPrincipal(r); err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.currentUserError(w, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("error while generating totp secret, %s", err)
+		app.errorJSON(w, errors.New("unable to set up two-factor authentication"), http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.MFA.Enroll(r.Context(), user.ID, secret)
+	if errors.Is(err, data.ErrMFAAlreadyEnabled) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error while enrolling totp, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to set up two-factor authentication"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "scan the provisioning uri with an authenticator app, then confirm with a code",
+		Data: map[string]string{
+			"secret":           secret,
+			"provisioning_uri": totp.URI(app.Settings.MFA.Issuer, user.Email, secret),
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ConfirmTOTP turns TOTP on once the user typed a valid code from their
+// authenticator, and returns their recovery codes. They are shown only once.
+func (app *Config) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	// tokens acting for the user must not change how they log in
+	if _, err := app.sessionPrincipal(r); err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.currentUserError(w, err)
+		return
+	}
+
+	var requestPayload struct {
+		Code string `json:"code" validate:"required"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	mfa, err := app.Models.MFA.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, data.ErrMFANotEnrolled, http.StatusBadRequest)
+		return
+	}
+	if mfa.Enabled {
+		app.errorJSON(w, data.ErrMFAAlreadyEnabled, http.StatusConflict)
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, requestPayload.Code, time.Now())
+	if !ok {
+		app.errorJSON(w, errInvalidCode, http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := data.NewRecoveryCodes()
+	if err == nil {
+		err = app.Models.MFA.Enable(r.Context(), user.ID, step, hashes)
+	}
+	if err != nil {
+		log.Printf("error while enabling totp, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to set up two-factor authentication"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%s] two-factor authentication enabled", user.Email)
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "two-factor authentication enabled, store the recovery codes somewhere safe",
+		Data: map[string]any{
+			"recovery_codes": codes,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// DisableTOTP turns TOTP off after checking a code or recovery code.
+func (app *Config) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	// tokens acting for the user must not change how they log in
+	if _, err := app.sessionPrincipal(r); err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.currentUserError(w, err)
+		return
+	}
+
+	var requestPayload secondFactor
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	err = app.verifySecondFactor(r.Context(), user.ID, requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = app.Models.MFA.Reset(r.Context(), user.ID); err != nil {
+		log.Printf("error while disabling totp, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to disable two-factor authentication"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%s] two-factor authentication disabled", user.Email)
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "two-factor authentication disabled",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// LoginMFA is the second step of a login with two-factor authentication: it
+// trades the challenge handed out for the password and a valid code for a
+// session.
+func (app *Config) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Challenge string `json:"challenge" validate:"required"`
+		secondFactor
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := app.Models.MFA.GetChallenge(r.Context(), requestPayload.Challenge)
+	if err != nil {
+		app.errorJSON(w, data.ErrChallengeNotFound, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Models.User.Get(r.Context(), challenge.UserID)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, data.ErrChallengeNotFound, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.verifySecondFactor(r.Context(), user.ID, requestPayload.secondFactor)
+	if err != nil {
+		if err := app.Models.MFA.FailChallenge(r.Context(), requestPayload.Challenge, maxChallengeAttempts); err != nil {
+			log.Printf("error while recording failed challenge, %s", err)
+		}
+		if err := app.Auth.ReportLogin(r.Context(), authclient.LoginFailed, user.Email, clientIP(r)); app.tooManyAttempts(w, err) {
+			return
+		}
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	// a challenge opens a single session, even when raced
+	if err = app.Models.MFA.ConsumeChallenge(r.Context(), requestPayload.Challenge); err != nil {
+		app.errorJSON(w, data.ErrChallengeNotFound, http.StatusUnauthorized)
+		return
+	}
+
+	if err := app.Auth.ReportLogin(r.Context(), authclient.LoginSucceeded, user.Email, clientIP(r)); err != nil {
+		log.Printf("error from authentication service, %s", err)
+	}
+
+	app.startSession(w, r, user, challenge.Device)
+}
+
+// ResetMFA turns off the two-factor authentication of a user who lost both
+// their authenticator and their recovery codes.
+func (app *Config) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.Get(r.Context(), id)
+	if err != nil {
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		}
+		return
+	}
+
+	if err = app.Models.MFA.Reset(r.Context(), user.ID); err != nil {
+		log.Printf("error while resetting two-factor authentication, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to reset two-factor authentication"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%s] two-factor authentication reset by an admin", user.Email)
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("two-factor authentication reset for user %s", user.Email),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// startMFAChallenge answers a login whose password matched with a challenge
+// to complete with a code at /user/login/mfa, instead of a session.
+func (app *Config) startMFAChallenge(w http.ResponseWriter, r *http.Request, user *data.User, device string) {
+	ttl := app.Settings.MFA.ChallengeTTL
+
+	challenge, err := app.Models.MFA.CreateChallenge(r.Context(), user.ID, device, ttl)
+	if err != nil {
+		log.Printf("error while creating two-factor challenge, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to log in, try again later"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "two-factor code required",
+		Data: map[string]any{
+			"mfa_required": true,
+			"challenge":    challenge,
+			"expires_in":   int(ttl.Seconds()),
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// verifySecondFactor checks the TOTP code, or else the recovery code, of the
+// user id and spends it.
+func (app *Config) verifySecondFactor(ctx context.Context, userID int, factor secondFactor) error {
+	mfa, err := app.Models.MFA.GetByUserID(ctx, userID)
+	if err != nil || !mfa.Enabled {
+		return data.ErrMFANotEnrolled
+	}
+
+	switch {
+	case factor.Code != "":
+		step, ok := totp.Validate(mfa.Secret, factor.Code, time.Now())
+		if !ok {
+			return errInvalidCode
+		}
+		if err := app.Models.MFA.UseStep(ctx, userID, step); err != nil {
+			if !errors.Is(err, data.ErrCodeAlreadyUsed) {
+				log.Printf("error while recording totp step, %s", err)
+			}
+			return errInvalidCode
+		}
+		return nil
+
+	case factor.RecoveryCode != "":
+		if err := app.Models.MFA.UseRecoveryCode(ctx, userID, factor.RecoveryCode); err != nil {
+			if !errors.Is(err, data.ErrInvalidRecoveryCode) {
+				log.Printf("error while spending recovery code, %s", err)
+			}
+			return data.ErrInvalidRecoveryCode
+		}
+
+		remaining, err := app.Models.MFA.RemainingRecoveryCodes(ctx, userID)
+		if err == nil && remaining == 0 {
+			log.Printf("[User=%d] used the last recovery code", userID)
+		}
+		return nil
+
+	default:
+		return errors.New("code or recovery_code is required")
+	}
+}
+
+// currentUser returns the user the request was authenticated as. It fails
+// with data.ErrUserNotFound when the user was deleted since.
+func (app *Config) currentUser(r *http.Request) (*data.User, error) {
+	principal, err := app.principal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.Models.User.Get(r.Context(), principal.UserID)
+}
+
+// currentUserError answers err returned by currentUser.
+func (app *Config) currentUserError(w http.ResponseWriter, err error) {
+	if app.contextError(w, err) {
+		return
+	}
+	if errors.Is(err, data.ErrUserNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	log.Printf("error while loading user, %s", err)
+	app.errorJSON(w, errors.New("unable to load user"), http.StatusInternalServerError)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"authentication-service/jwt"
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+	"user-service/data"
+)
+
+func TestMFARequiresSession(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+
+	pat, _, err := ta.app.Models.PersonalAccessToken.Insert(context.Background(), id, "bot", []string{scopeUsersWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	client, err := jwt.Sign(&jwt.Claims{
+		Subject:   strconv.Itoa(id),
+		Email:     "ada@example.com",
+		Scope:     scopeUsersRead + " " + scopeUsersWrite,
+		SessionID: testSessionID,
+		ClientID:  "third-party-app",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+	}, testKeyID, ta.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/user/mfa/totp"},
+		{http.MethodPost, "/user/mfa/totp/confirm"},
+		{http.MethodDelete, "/user/mfa/totp"},
+	}
+	tokens := []struct {
+		name  string
+		token string
+	}{
+		{"personal access token", pat},
+		{"third party client token", client},
+	}
+
+	for _, route := range routes {
+		for _, tt := range tokens {
+			t.Run(route.method+" "+route.path+" with a "+tt.name, func(t *testing.T) {
+				w := ta.serve(route.method, route.path, map[string]string{"code": "123456"}, "Authorization", "Bearer "+tt.token)
+				if w.Code != http.StatusForbidden {
+					t.Errorf("status = %d, want %d, body %s", w.Code, http.StatusForbidden, w.Body)
+				}
+			})
+		}
+	}
+
+	if _, err := ta.mfa.GetByUserID(context.Background(), id); err != data.ErrMFANotEnrolled {
+		t.Errorf("GetByUserID = %v, want %v", err, data.ErrMFANotEnrolled)
+	}
+
+	session := ta.accessToken(t, id, "ada@example.com", testSessionID)
+	if w := ta.serve(http.MethodPost, "/user/mfa/totp", nil, "Authorization", "Bearer "+session); w.Code != http.StatusAccepted {
+		t.Errorf("status with a login session = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+}
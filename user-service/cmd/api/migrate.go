@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"user-service/config"
+	"user-service/migrate"
+)
+
+const migrateUsage = "usage: migrate [up | down [steps] | status]"
+
+// runMigrate runs the migrate command: up applies pending migrations, down
+// rolls back the last steps ones, one by default, and status lists them.
+func runMigrate(settings *config.Config, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+		args = args[1:]
+	}
+
+	steps := 1
+	switch {
+	case command == "down" && len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("steps must be a positive number, got %q", args[0])
+		}
+		steps = n
+	case command != "up" && command != "down" && command != "status", len(args) > 0:
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := connectToDB(settings.Database.DSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch command {
+	case "up":
+		return migrateUp(conn)
+	case "down":
+		return migrateDown(conn, steps)
+	default:
+		return migrationStatus(conn)
+	}
+}
+
+// migrateUp applies the pending migrations, waiting for other replicas doing
+// the same.
+func migrateUp(conn *sql.DB) error {
+	migrator, err := migrate.New(conn)
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(context.Background())
+	for _, migration := range applied {
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		log.Println("Database schema is up to date")
+	}
+
+	return nil
+}
+
+// migrateOnStart applies the pending migrations over a connection of its own,
+// closed once they are done.
+func migrateOnStart(dsn string) error {
+	conn, err := connectToDB(dsn)
+	if err != nil {
+		return fmt.Errorf("connecting to database, %w", err)
+	}
+	defer conn.Close()
+
+	return migrateUp(conn)
+}
+
+func migrateDown(conn *sql.DB, steps int) error {
+	migrator, err := migrate.New(conn)
+	if err != nil {
+		return err
+	}
+
+	rolledBack, err := migrator.Down(context.Background(), steps)
+	for _, migration := range rolledBack {
+		log.Printf("Rolled back migration %04d_%s", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(rolledBack) == 0 {
+		log.Println("No migration to roll back")
+	}
+
+	return nil
+}
+
+func migrationStatus(conn *sql.DB) error {
+	migrator, err := migrate.New(conn)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if status.Unknown {
+			appliedAt += " (unknown to this build)"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"authentication-service/authclient"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"user-service/data"
+	"user-service/mailer"
+)
+
+// passwordResetTokenName signs the emailed password reset tokens.
+const passwordResetTokenName = "password_reset"
+
+// ForgotPassword mails a password reset link to the account of an email, at
+// most once per resend interval. The answer does not tell whether such an
+// account exists.
+func (app *Config) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "if the account exists, an email to reset its password is on its way",
+	}
+
+	user, err := app.Models.User.GetByEmail(r.Context(), requestPayload.Email)
+	if errors.Is(err, data.ErrUserNotFound) {
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+	if err != nil {
+		log.Printf("error while loading user, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to send password reset email"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	lastSent, err := app.Models.PasswordReset.LastSent(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error while loading password reset, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to send password reset email"), http.StatusInternalServerError)
+		}
+		return
+	}
+	// answered like any other request, a refusal would tell the account exists
+	if time.Since(lastSent) < app.Settings.PasswordReset.ResendInterval {
+		log.Printf("[User=%s] password reset email sent recently, not sending another", user.Email)
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+
+	if err = app.sendPasswordReset(r.Context(), user); err != nil {
+		log.Printf("error while sending password reset email, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to send password reset email"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ResetPassword sets a new password with the token of a password reset email
+// and ends every session of the user.
+func (app *Config) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8,max=72"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.Cookies.Decode(passwordResetTokenName, requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, data.ErrPasswordResetNotFound, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := app.Models.PasswordReset.Consume(r.Context(), token)
+	if errors.Is(err, data.ErrPasswordResetNotFound) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	// the token is used up either way, should this fail the user asks for another
+	if err == nil {
+		err = app.Models.User.ResetPassword(r.Context(), userID, requestPayload.Password)
+	}
+	var user *data.User
+	if err == nil {
+		user, err = app.Models.User.Get(r.Context(), userID)
+	}
+	if err != nil {
+		log.Printf("error while resetting password, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to reset password"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%s] password reset", user.Email)
+
+	if !app.endAccessAfterPasswordChange(w, r, user) {
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "password reset, log in with the new password",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ChangePassword replaces the password of the logged in user, who has to type
+// their current one, and ends every session of theirs, this one included.
+func (app *Config) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	// tokens acting for the user must not take the account over
+	principal, err := app.sessionPrincipal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	user, err := app.Models.User.Get(r.Context(), principal.UserID)
+	if err != nil {
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		}
+		return
+	}
+
+	// guessing the current password counts towards the login lockout
+	ip := clientIP(r)
+	err = app.Auth.ReportLogin(r.Context(), authclient.LoginAttempted, user.Email, ip)
+	if err != nil {
+		if !app.tooManyAttempts(w, err) {
+			log.Printf("error from authentication service, %s", err)
+			app.errorJSON(w, errors.New("unable to change password, try again later"), http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	match, err := user.PasswordMatches(requestPayload.CurrentPassword)
+	if err != nil || !match {
+		if err != nil {
+			log.Printf("Error while matching password. %v", err)
+		}
+
+		if err := app.Auth.ReportLogin(r.Context(), authclient.LoginFailed, user.Email, ip); app.tooManyAttempts(w, err) {
+			return
+		}
+		app.errorJSON(w, errors.New("current password doesnt match"), http.StatusBadRequest)
+		return
+	}
+
+	if err = app.Models.User.ResetPassword(r.Context(), user.ID, requestPayload.NewPassword); err != nil {
+		log.Printf("error while changing password, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to change password"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%s] password changed", user.Email)
+
+	if err := app.Auth.ReportLogin(r.Context(), authclient.LoginSucceeded, user.Email, ip); err != nil {
+		log.Printf("error from authentication service, %s", err)
+	}
+
+	if !app.endAccessAfterPasswordChange(w, r, user) {
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "password changed, log in with the new password",
+	}
+
+	app.clearSessionCookies(w)
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// sendPasswordReset mails user a link to set a new password.
+func (app *Config) sendPasswordReset(ctx context.Context, user *data.User) error {
+	token, err := app.Models.PasswordReset.Create(ctx, user.ID, app.Settings.PasswordReset.TokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link, err := app.tokenLink(app.Settings.PasswordReset.URL, passwordResetTokenName, token)
+	if err != nil {
+		return err
+	}
+
+	return app.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to choose a new password. It works once, within %s.\n\n%s\n\nIf you did not ask for this, ignore this email, your password stays the same.\n",
+			user.FirstName, app.Settings.PasswordReset.TokenTTL, link),
+	})
+}
+
+// endAccessAfterPasswordChange ends every session and revokes every personal
+// access token of user, so whoever knew the old password is locked out, and
+// reports whether it did. Otherwise it answers the request, telling that the
+// password was changed all the same.
+func (app *Config) endAccessAfterPasswordChange(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	tokens, err := app.Models.PersonalAccessToken.RevokeAll(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error while revoking personal access tokens after password change, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("password changed but unable to revoke personal access tokens, revoke them from the tokens page"), http.StatusInternalServerError)
+		}
+		return false
+	}
+
+	log.Printf("[User=%s] %d personal access tokens revoked after password change", user.Email, tokens)
+
+	revoked, err := app.Auth.RevokeAllSessions(r.Context(), user.Email, "")
+	if err != nil {
+		log.Printf("error while ending sessions after password change, %s", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, authclient.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		app.errorJSON(w, errors.New("password changed but unable to end existing sessions, log out of them from the sessions page"), status)
+		return false
+	}
+
+	log.Printf("[User=%s] %d sessions ended after password change", user.Email, revoked)
+
+	return true
+}
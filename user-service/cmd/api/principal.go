@@ -0,0 +1,146 @@
+package main
+
+import (
+	"authentication-service/jwt"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// authRealm names the protection space in WWW-Authenticate challenges.
+const authRealm = "user-service"
+
+type contextKey string
+
+// principalContextKey holds the *Principal of an authenticated request.
+const principalContextKey contextKey = "principal"
+
+// Principal is who a request was authenticated as, set in the request context
+// by the authenticate middleware. Handlers act on the principal only, never
+// on a user named in the request.
+type Principal struct {
+	UserID int
+	Email  string
+	// SessionID is empty for tokens not tied to a login session, such as
+	// personal access tokens.
+	SessionID   string
+	Scopes      []string
+	Roles       []string
+	Permissions []string
+	// ClientID names the third party client acting for the user, if any.
+	ClientID string
+	// TokenID identifies the token the request was made with.
+	TokenID string
+}
+
+// newPrincipal returns the principal of verified access token claims.
+func newPrincipal(claims *jwt.Claims) (*Principal, error) {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, errors.New("token is not issued to a user")
+	}
+
+	return &Principal{
+		UserID:      userID,
+		Email:       claims.Email,
+		SessionID:   claims.SessionID,
+		Scopes:      claims.Scopes(),
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+		ClientID:    claims.ClientID,
+		TokenID:     claims.ID,
+	}, nil
+}
+
+// HasScope reports whether the token of the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// HasPermission reports whether the roles of the principal grant permission.
+func (p *Principal) HasPermission(permission string) bool {
+	return contains(p.Permissions, permission)
+}
+
+func withPrincipal(r *http.Request, principal *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey, principal))
+}
+
+// principal returns the principal the request was authenticated as.
+func (app *Config) principal(r *http.Request) (*Principal, error) {
+	principal, ok := r.Context().Value(principalContextKey).(*Principal)
+	if !ok {
+		return nil, errors.New("invalid session")
+	}
+
+	return principal, nil
+}
+
+// sessionPrincipal returns the principal of the request when it comes from a
+// login session. Tokens that act on behalf of a user, personal access tokens
+// and third party ones, cannot be used to mint more tokens.
+func (app *Config) sessionPrincipal(r *http.Request) (*Principal, error) {
+	principal, err := app.principal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if principal.SessionID == "" || principal.ClientID != "" {
+		return nil, errors.New("this requires a login session")
+	}
+
+	return principal, nil
+}
+
+// accessTokenPrincipal verifies an access token against the keys published by
+// the authentication service and returns the principal it was issued to. The
+// signature is trusted for the short life of the token, without asking the
+// authentication service, so only sessions ended through this replica are
+// refused before their tokens expire. Routes changing credentials or sessions
+// check with requireLiveSession as well.
+func (app *Config) accessTokenPrincipal(token string) (*Principal, error) {
+	claims, err := app.Keys.Verify(token)
+	if err != nil {
+		log.Printf("Invalid access token, %s", err)
+		return nil, errors.New("invalid session")
+	}
+
+	if claims.SessionID != "" && app.Auth.Revoked(claims.Email, claims.SessionID, time.Unix(claims.IssuedAt, 0)) {
+		return nil, errors.New("invalid session")
+	}
+
+	return newPrincipal(claims)
+}
+
+// bearerToken returns the token of the Authorization header, or an empty
+// string when there is no such header.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", nil
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", errors.New("authorization header must be Bearer <token>")
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+// unauthorized answers 401 with the challenge of RFC 6750. code is the error
+// of the challenge, left out when the request carried no credentials.
+func (app *Config) unauthorized(w http.ResponseWriter, err error, code string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if code != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", code, err.Error())
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	app.errorJSON(w, err, http.StatusUnauthorized)
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"authentication-service/authclient"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"log"
 	"net/http"
+	"strings"
+	"user-service/data"
+)
+
+// Scopes an access token needs for the user endpoints. Tokens issued on login
+// carry all of them, tokens issued to third party clients only those the user
+// consented to.
+const (
+	scopeUsersRead  = "users.read"
+	scopeUsersWrite = "users.write"
 )
 
 type AuthRequest struct {
@@ -14,14 +27,40 @@ type AuthRequest struct {
 	Token string `json:"token"`
 }
 
+type LoginAttempt struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+type SessionRequest struct {
+	UserID      int      `json:"user_id"`
+	Email       string   `json:"email"`
+	Device      string   `json:"device"`
+	IP          string   `json:"ip"`
+	UserAgent   string   `json:"user_agent"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+type TokenResponse struct {
+	Email            string `json:"email"`
+	Token            string `json:"token"`
+	SessionID        string `json:"session_id"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+}
+
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"http://*", "https://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedOrigins:   app.Settings.CORS.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"link"},
+		ExposedHeaders:   []string{"link", "WWW-Authenticate"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -30,36 +69,195 @@ func (app *Config) routes() http.Handler {
 
 	mux.Post("/user/signup", app.Signup)
 	mux.Post("/user/login", app.Login)
-	mux.With(app.authenticate).Delete("/user/logout", app.Logout)
-	mux.With(app.authenticate).Get("/user/profile", app.UserProfile)
+	mux.Post("/user/login/mfa", app.LoginMFA)
+	mux.Post("/user/token/refresh", app.RefreshToken)
+	mux.Get("/user/verify", app.VerifyEmail)
+	mux.Post("/user/verify", app.VerifyEmail)
+	mux.Post("/user/verify/resend", app.ResendVerification)
+	mux.Post("/user/password/forgot", app.ForgotPassword)
+	mux.Post("/user/password/reset", app.ResetPassword)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite)).Delete("/user/logout", app.Logout)
+	mux.With(app.authenticate, app.requireScope(scopeUsersRead)).Get("/user/profile", app.UserProfile)
+	mux.With(app.authenticate, app.requireScope(scopeUsersRead)).Get("/me", app.UserProfile)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite)).Patch("/me", app.UpdateProfile)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite)).Delete("/me/session", app.Logout)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Put("/user/password", app.ChangePassword)
+	mux.With(app.authenticate, app.requireScope(scopeUsersRead), app.requireLiveSession).Get("/user/sessions", app.ListSessions)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Delete("/user/sessions", app.RevokeOtherSessions)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Delete("/user/sessions/{id}", app.RevokeSession)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Post("/user/mfa/totp", app.EnrollTOTP)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Post("/user/mfa/totp/confirm", app.ConfirmTOTP)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Delete("/user/mfa/totp", app.DisableTOTP)
+
+	mux.With(app.authenticate, app.requireScope(scopeUsersRead), app.requireLiveSession).Get("/user/tokens", app.ListPersonalAccessTokens)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Post("/user/tokens", app.CreatePersonalAccessToken)
+	mux.With(app.authenticate, app.requireScope(scopeUsersWrite), app.requireLiveSession).Delete("/user/tokens/{id}", app.RevokePersonalAccessToken)
+
+	mux.With(app.RequirePermission(permissionUsersRead)).Get("/admin/users", app.ListUsers)
+	mux.With(app.RequirePermission(permissionUsersDelete)).Delete("/admin/users/{id}", app.DeleteUser)
+	mux.With(app.RequirePermission(permissionMFAReset)).Delete("/admin/users/{id}/mfa", app.ResetMFA)
+	mux.With(app.RequirePermission(permissionRolesManage)).Get("/admin/roles", app.ListRoles)
+	mux.With(app.RequirePermission(permissionRolesManage)).Get("/admin/users/{id}/roles", app.UserRoles)
+	mux.With(app.RequirePermission(permissionRolesManage)).Post("/admin/users/{id}/roles", app.GrantRole)
+	mux.With(app.RequirePermission(permissionRolesManage)).Delete("/admin/users/{id}/roles/{role}", app.RevokeRole)
+	mux.With(app.requireAdminKey).Get("/admin/metrics/db", app.DatabaseStats)
 
 	return mux
 }
 
+// authenticate identifies the caller by the bearer token of the Authorization
+// header, or else by the access token cookie set on login, and puts who they
+// are in the request context. Scripts and bots send personal access tokens,
+// apps the access tokens of their session.
 func (app *Config) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println("calling authentication service")
-
-		emailCookie, err := r.Cookie("email")
+		token, err := bearerToken(r)
 		if err != nil {
-			log.Print("user cookie not present")
-			app.errorJSON(w, errors.New("invalid session"))
+			app.unauthorized(w, err, "invalid_request")
+			return
+		}
+		if token == "" {
+			token, err = app.readCookie(r, accessTokenCookie)
+			if err != nil {
+				app.unauthorized(w, err, "invalid_token")
+				return
+			}
+			// browsers send cookies along with forged requests, headers they do not
+			if token != "" {
+				if err := app.checkCSRF(r); err != nil {
+					app.errorJSON(w, err, http.StatusForbidden)
+					return
+				}
+			}
+		}
+		if token == "" {
+			app.unauthorized(w, errors.New("authentication required"), "")
 			return
 		}
 
-		token, err := r.Cookie("Authorization")
+		var principal *Principal
+		if strings.HasPrefix(token, data.PersonalAccessTokenPrefix) {
+			principal, err = app.personalAccessPrincipal(r.Context(), token)
+		} else {
+			principal, err = app.accessTokenPrincipal(token)
+		}
 		if err != nil {
-			log.Print("Authorization cookie not present")
-			app.errorJSON(w, errors.New("invalid session"))
+			if !app.authUnavailable(w, err) {
+				app.unauthorized(w, err, "invalid_token")
+			}
 			return
 		}
-		_, err = app.validateToken(emailCookie.Value, token.Value)
+
+		// Validation passed, call the next handler in the chain
+		next.ServeHTTP(w, withPrincipal(r, principal))
+	})
+}
+
+// requireScope refuses requests whose access token was not granted scope.
+func (app *Config) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := app.principal(r)
+			if err != nil {
+				app.errorJSON(w, errors.New("invalid session"), http.StatusUnauthorized)
+				return
+			}
+
+			if !principal.HasScope(scope) {
+				log.Printf("access token lacks scope %s", scope)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="insufficient_scope", scope=%q`, authRealm, scope))
+				app.errorJSON(w, fmt.Errorf("insufficient scope, %s required", scope), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireLiveSession refuses access tokens of sessions that ended, asking the
+// authentication service. Tokens are otherwise trusted by their signature until
+// they expire, which is too long for the routes changing credentials or
+// sessions when the session was ended by another replica or by the
+// authentication service itself.
+func (app *Config) requireLiveSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := app.principal(r)
 		if err != nil {
-			app.errorJSON(w, errors.New("invalid session"))
+			app.errorJSON(w, errors.New("invalid session"), http.StatusUnauthorized)
+			return
+		}
+
+		// personal access tokens are looked up on every request already
+		if principal.SessionID != "" {
+			_, err = app.Auth.Session(r.Context(), principal.Email, principal.SessionID)
+			if errors.Is(err, authclient.ErrNotFound) {
+				app.unauthorized(w, errors.New("invalid session"), "invalid_token")
+				return
+			}
+			if err != nil {
+				log.Printf("error while checking session, %s", err)
+				if !app.authUnavailable(w, err) {
+					app.errorJSON(w, errors.New("unable to check session"), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission only lets through logged in users whose roles grant
+// permission. Operators may use the admin API key instead, sent in the
+// X-Admin-Key header.
+func (app *Config) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		check := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := app.principal(r)
+			if err != nil {
+				app.errorJSON(w, errors.New("invalid session"), http.StatusUnauthorized)
+				return
+			}
+
+			if !principal.HasPermission(permission) {
+				log.Printf("[User=%s] lacks permission %s", principal.Email, permission)
+				app.errorJSON(w, fmt.Errorf("permission %s required", permission), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+
+		withKey := app.requireAdminKey(next)
+		withSession := app.authenticate(check)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Admin-Key") != "" {
+				withKey.ServeHTTP(w, r)
+				return
+			}
+
+			withSession.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireAdminKey only lets through requests carrying the admin API key in
+// the X-Admin-Key header.
+func (app *Config) requireAdminKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := app.Settings.AdminAPIKey
+		if key == "" {
+			app.errorJSON(w, errors.New("admin endpoints are disabled"), http.StatusForbidden)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(key)) != 1 {
+			app.errorJSON(w, errors.New("Request unauthorized"), http.StatusUnauthorized)
 			return
 		}
 
-		// Validation passed, call the next handler in the chain
 		next.ServeHTTP(w, r)
 	})
 }
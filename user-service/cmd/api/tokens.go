@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"user-service/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxTokenNameLength matches the name column of personal_access_tokens.
+const maxTokenNameLength = 255
+
+// scopeAdmin lets a personal access token use the permissions the roles of its
+// user grant. Without it the token cannot reach the admin endpoints, whatever
+// the roles of its user.
+const scopeAdmin = "admin"
+
+// personalAccessTokenScopes are the scopes a personal access token can be
+// given.
+var personalAccessTokenScopes = []string{"tweet.read", "tweet.write", scopeUsersRead, scopeUsersWrite, scopeAdmin}
+
+// CreatePersonalAccessToken creates a named token with the chosen scopes and an
+// optional expiry. The token is only shown in this response.
+func (app *Config) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	principal, err := app.sessionPrincipal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	var requestPayload struct {
+		Name      string     `json:"name" validate:"required"`
+		Scopes    []string   `json:"scopes" validate:"required,min=1"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	if len(requestPayload.Name) > maxTokenNameLength {
+		app.errorJSON(w, fmt.Errorf("name must be at most %d characters", maxTokenNameLength), http.StatusBadRequest)
+		return
+	}
+	for _, scope := range requestPayload.Scopes {
+		if !contains(personalAccessTokenScopes, scope) {
+			app.errorJSON(w, fmt.Errorf("unknown scope %s", scope), http.StatusBadRequest)
+			return
+		}
+	}
+	if requestPayload.ExpiresAt != nil && !requestPayload.ExpiresAt.After(time.Now()) {
+		app.errorJSON(w, errors.New("expires_at must be in the future"), http.StatusBadRequest)
+		return
+	}
+
+	plaintext, token, err := app.Models.PersonalAccessToken.Insert(r.Context(), principal.UserID, requestPayload.Name, requestPayload.Scopes, requestPayload.ExpiresAt)
+	if err != nil {
+		log.Printf("error while creating personal access token, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to create token"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%s] personal access token %d created", principal.Email, token.ID)
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "token created, copy it now as it will not be shown again",
+		Data: map[string]any{
+			"token":   plaintext,
+			"details": token,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ListPersonalAccessTokens lists the tokens of the user with when they were
+// last used. The tokens themselves are never shown again.
+func (app *Config) ListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	principal, err := app.principal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := app.Models.PersonalAccessToken.GetAllByUserID(r.Context(), principal.UserID)
+	if err != nil {
+		log.Printf("error while listing personal access tokens, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to list tokens"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "tokens fetched successfully",
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// RevokePersonalAccessToken deletes a token of the user.
+func (app *Config) RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	principal, err := app.principal(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, data.ErrTokenNotFound, http.StatusNotFound)
+		return
+	}
+
+	err = app.Models.PersonalAccessToken.Revoke(r.Context(), principal.UserID, id)
+	if errors.Is(err, data.ErrTokenNotFound) {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error while revoking personal access token, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to revoke token"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%s] personal access token %d revoked", principal.Email, id)
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "token revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// personalAccessPrincipal checks a personal access token and returns the
+// principal it stands for, carrying its scopes. The permissions of the roles
+// of its user only come along with the admin scope.
+func (app *Config) personalAccessPrincipal(ctx context.Context, plaintext string) (*Principal, error) {
+	token, err := app.Models.PersonalAccessToken.Authenticate(ctx, plaintext)
+	if err != nil {
+		if !errors.Is(err, data.ErrTokenNotFound) {
+			log.Printf("error while checking personal access token, %s", err)
+		}
+		return nil, errors.New("invalid token")
+	}
+
+	// roles are looked up on every request so revoking one takes effect at once
+	roles, permissions, err := app.Models.Role.GetForUser(ctx, token.UserID)
+	if err != nil {
+		log.Printf("error while loading roles, %s", err)
+		return nil, errors.New("invalid token")
+	}
+	if !contains(token.Scopes, scopeAdmin) {
+		permissions = []string{}
+	}
+
+	return &Principal{
+		UserID:      token.UserID,
+		Email:       token.Email,
+		Scopes:      token.Scopes,
+		Roles:       roles,
+		Permissions: permissions,
+		TokenID:     "pat:" + strconv.Itoa(token.ID),
+	}, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
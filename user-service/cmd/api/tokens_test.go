@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"user-service/data"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addUser(t, "ada@example.com", data.UserStatusActive)
+	session := "Bearer " + ta.accessToken(t, id, "ada@example.com", testSessionID)
+
+	body := map[string]any{"name": "deploy bot", "scopes": []string{scopeUsersRead}}
+	w := ta.serve(http.MethodPost, "/user/tokens", body, "Authorization", session)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	var created struct {
+		Data struct {
+			Token   string                   `json:"token"`
+			Details data.PersonalAccessToken `json:"details"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	pat := "Bearer " + created.Data.Token
+
+	if w := ta.serve(http.MethodGet, "/user/tokens", nil, "Authorization", pat); w.Code != http.StatusAccepted {
+		t.Errorf("status listing with the token = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	if w := ta.serve(http.MethodPatch, "/me", map[string]string{"first_name": "Augusta"}, "Authorization", pat); w.Code != http.StatusForbidden {
+		t.Errorf("status writing with a read only token = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	path := "/user/tokens/" + strconv.Itoa(created.Data.Details.ID)
+	if w := ta.serve(http.MethodDelete, path, nil, "Authorization", session); w.Code != http.StatusAccepted {
+		t.Fatalf("status revoking = %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	if w := ta.serve(http.MethodGet, "/user/tokens", nil, "Authorization", pat); w.Code != http.StatusUnauthorized {
+		t.Errorf("status with a revoked token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
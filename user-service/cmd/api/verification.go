@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"user-service/data"
+	"user-service/mailer"
+)
+
+// verificationTokenName signs the emailed verification tokens, so they cannot
+// be mistaken for any cookie signed with the same key.
+const verificationTokenName = "email_verification"
+
+var errEmailNotVerified = errors.New("email not verified, check your inbox or ask for another email")
+
+// sendVerification mails user a link to verify their email.
+func (app *Config) sendVerification(ctx context.Context, user *data.User) error {
+	token, err := app.Models.EmailVerification.Create(ctx, user.ID, app.Settings.Verification.TokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link, err := app.tokenLink(app.Settings.Verification.URL, verificationTokenName, token)
+	if err != nil {
+		return err
+	}
+
+	return app.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to verify your email. It works once, within %s.\n\n%s\n\nIf you did not sign up, ignore this email.\n",
+			user.FirstName, app.Settings.Verification.TokenTTL, link),
+	})
+}
+
+// VerifyEmail activates the account a verification token was mailed to. The
+// token comes in the token query parameter of the emailed link, or in a JSON
+// body.
+func (app *Config) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	requestPayload.Token = r.URL.Query().Get("token")
+	if requestPayload.Token == "" {
+		err := app.readJSON(w, r, &requestPayload)
+		if err != nil {
+			log.Print(err)
+			app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, err := app.Cookies.Decode(verificationTokenName, requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, data.ErrVerificationNotFound, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := app.Models.EmailVerification.Verify(r.Context(), token)
+	if errors.Is(err, data.ErrVerificationNotFound) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("error while verifying email, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to verify email"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("[User=%d] email verified", userID)
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "email verified, you can now log in",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ResendVerification mails another verification link to a pending account,
+// at most once per resend interval. The answer does not tell whether such an
+// account exists.
+func (app *Config) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		log.Print(err)
+		app.errorJSON(w, errors.New(fmt.Sprintf("Error while reading request. Error : %s", err)), http.StatusBadRequest)
+		return
+	}
+
+	payload := JsonResponse{
+		Error:   false,
+		Message: "if the account is waiting for verification, an email is on its way",
+	}
+
+	user, err := app.Models.User.GetByEmail(r.Context(), requestPayload.Email)
+	if errors.Is(err, data.ErrUserNotFound) || (err == nil && user.Status != data.UserStatusPending) {
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+	if err != nil {
+		log.Printf("error while loading user, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to send verification email"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	lastSent, err := app.Models.EmailVerification.LastSent(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error while loading verification, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to send verification email"), http.StatusInternalServerError)
+		}
+		return
+	}
+	if wait := time.Until(lastSent.Add(app.Settings.Verification.ResendInterval)); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		app.errorJSON(w, errors.New("a verification email was sent recently, try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	if err = app.sendVerification(r.Context(), user); err != nil {
+		log.Printf("error while sending verification email, %s", err)
+		if !app.contextError(w, err) {
+			app.errorJSON(w, errors.New("unable to send verification email"), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
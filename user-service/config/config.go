@@ -0,0 +1,572 @@
+// Package config loads the settings of the user service from defaults, an
+// optional YAML file and environment variables, in increasing precedence.
+// The database DSN may instead be read from the file named by DSN_FILE.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[redacted]"
+
+type Config struct {
+	WebPort  string         `yaml:"web_port"`
+	Database DatabaseConfig `yaml:"database"`
+	Auth     AuthConfig     `yaml:"auth"`
+	MFA      MFAConfig      `yaml:"mfa"`
+	Cookies  CookieConfig   `yaml:"cookies"`
+	CORS     CORSConfig     `yaml:"cors"`
+	Mail     MailConfig     `yaml:"mail"`
+	// Verification covers the emails proving users own the email they signed
+	// up with.
+	Verification VerificationConfig `yaml:"verification"`
+	// PasswordReset covers the emails sent to users who forgot their password.
+	PasswordReset PasswordResetConfig `yaml:"password_reset"`
+	// AdminAPIKey guards the admin endpoints, which are disabled when it is empty.
+	AdminAPIKey     string `yaml:"admin_api_key"`
+	AdminAPIKeyFile string `yaml:"admin_api_key_file"`
+}
+
+type DatabaseConfig struct {
+	DSN     string `yaml:"dsn"`
+	DSNFile string `yaml:"dsn_file"`
+	// MigrateOnStart applies pending schema migrations when the service
+	// starts. Without it they are applied with the migrate command.
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+	// QueryTimeout bounds each database operation, which also stops when the
+	// request it serves is cancelled.
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// MaxConns and MinConns bound the connections of the pool, which keeps
+	// MinConns open even when idle.
+	MaxConns int `yaml:"max_conns"`
+	MinConns int `yaml:"min_conns"`
+	// MaxConnLifetime and MaxConnIdleTime close connections that have been
+	// open or unused that long.
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time"`
+	// HealthCheckPeriod is how often idle connections are checked and closed
+	// past their lifetime.
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+}
+
+type AuthConfig struct {
+	// URL is the base URL of the authentication service.
+	URL string `yaml:"url"`
+	// JWKSURL serves the access token verification keys, by default the
+	// well known path of URL.
+	JWKSURL string `yaml:"jwks_url"`
+	// Timeout bounds each attempt of a call to the authentication service.
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is how many times a failed call is retried.
+	Retries int `yaml:"retries"`
+	// BreakerThreshold is how many failed calls in a row make the service be
+	// treated as down for BreakerCooldown, failing calls at once meanwhile.
+	// Zero disables the breaker.
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+	// AccessTokenTTL matches tokens.access_token_ttl of the authentication
+	// service. Access tokens are trusted by their signature, sessions ended
+	// here are remembered that long to refuse their tokens until they expire.
+	// Sessions ended elsewhere are only checked for on the routes changing
+	// credentials or sessions, elsewhere their tokens work for up to this
+	// long.
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl"`
+	// ServiceKey authenticates this service to the authentication service,
+	// which only opens sessions for its callers.
+	ServiceKey     string `yaml:"service_key"`
+	ServiceKeyFile string `yaml:"service_key_file"`
+}
+
+type MFAConfig struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string `yaml:"issuer"`
+	// ChallengeTTL is how long a user has to type their code after their
+	// password was accepted.
+	ChallengeTTL time.Duration `yaml:"challenge_ttl"`
+}
+
+type CookieConfig struct {
+	// Secure only lets browsers send the session cookies over https.
+	Secure bool `yaml:"secure"`
+	// SameSite is lax, strict or none. none requires Secure.
+	SameSite string `yaml:"same_site"`
+	Domain   string `yaml:"domain"`
+	// SigningKey signs the session cookies and the links emailed for email
+	// verification and password resets. It is required, and has to be the
+	// same on every replica and across restarts for them to stay valid.
+	SigningKey     string `yaml:"signing_key"`
+	SigningKeyFile string `yaml:"signing_key_file"`
+	// EncryptionKey, when set, encrypts the session cookies too.
+	EncryptionKey     string `yaml:"encryption_key"`
+	EncryptionKeyFile string `yaml:"encryption_key_file"`
+}
+
+type CORSConfig struct {
+	// AllowedOrigins may call the API from a browser with cookies, each
+	// written as scheme://host[:port].
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+type MailConfig struct {
+	// Driver is smtp, or log or file for local development.
+	Driver string `yaml:"driver"`
+	// From is the sender of every email, such as
+	// "go-twitter <no-reply@example.com>".
+	From string `yaml:"from"`
+	// Dir is where the file driver writes the emails.
+	Dir  string     `yaml:"dir"`
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	// Addr is the host:port of the relay.
+	Addr         string `yaml:"addr"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type VerificationConfig struct {
+	// URL is the page the emailed link points to, which gets the token in its
+	// token query parameter.
+	URL string `yaml:"url"`
+	// TokenTTL is how long an emailed link works.
+	TokenTTL time.Duration `yaml:"token_ttl"`
+	// ResendInterval is how long a user waits before another email is sent.
+	ResendInterval time.Duration `yaml:"resend_interval"`
+}
+
+type PasswordResetConfig struct {
+	// URL is the page the emailed link points to, which gets the token in its
+	// token query parameter and posts it back with the new password.
+	URL string `yaml:"url"`
+	// TokenTTL is how long an emailed link works.
+	TokenTTL time.Duration `yaml:"token_ttl"`
+	// ResendInterval is how long a user waits before another email is sent.
+	ResendInterval time.Duration `yaml:"resend_interval"`
+}
+
+// Default returns the settings used when nothing overrides them, matching
+// the docker-compose setup.
+func Default() *Config {
+	return &Config{
+		WebPort: "80",
+		Database: DatabaseConfig{
+			MigrateOnStart:    true,
+			QueryTimeout:      3 * time.Second,
+			MaxConns:          10,
+			MinConns:          2,
+			MaxConnLifetime:   time.Hour,
+			MaxConnIdleTime:   30 * time.Minute,
+			HealthCheckPeriod: time.Minute,
+		},
+		Auth: AuthConfig{
+			URL:              "http://authentication-service",
+			Timeout:          5 * time.Second,
+			Retries:          2,
+			BreakerThreshold: 5,
+			BreakerCooldown:  10 * time.Second,
+			AccessTokenTTL:   15 * time.Minute,
+		},
+		MFA: MFAConfig{
+			Issuer:       "go-twitter",
+			ChallengeTTL: 5 * time.Minute,
+		},
+		Cookies: CookieConfig{
+			Secure:   true,
+			SameSite: "lax",
+		},
+		Mail: MailConfig{
+			Driver: "log",
+			From:   "go-twitter <no-reply@localhost>",
+			Dir:    "mail",
+		},
+		Verification: VerificationConfig{
+			URL:            "http://localhost:8081/user/verify",
+			TokenTTL:       24 * time.Hour,
+			ResendInterval: time.Minute,
+		},
+		PasswordReset: PasswordResetConfig{
+			URL:            "http://localhost:8081/user/password/reset",
+			TokenTTL:       time.Hour,
+			ResendInterval: time.Minute,
+		},
+	}
+}
+
+// Load builds the settings from the defaults, the YAML file at path when path
+// is not empty, and the environment, then validates them.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.readEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := readSecretFile(&cfg.Database.DSN, cfg.Database.DSNFile, "database dsn"); err != nil {
+		return nil, err
+	}
+	if err := readSecretFile(&cfg.AdminAPIKey, cfg.AdminAPIKeyFile, "admin api key"); err != nil {
+		return nil, err
+	}
+	if err := readSecretFile(&cfg.Auth.ServiceKey, cfg.Auth.ServiceKeyFile, "auth service key"); err != nil {
+		return nil, err
+	}
+	if err := readSecretFile(&cfg.Cookies.SigningKey, cfg.Cookies.SigningKeyFile, "cookie signing key"); err != nil {
+		return nil, err
+	}
+	if err := readSecretFile(&cfg.Cookies.EncryptionKey, cfg.Cookies.EncryptionKeyFile, "cookie encryption key"); err != nil {
+		return nil, err
+	}
+	if err := readSecretFile(&cfg.Mail.SMTP.Password, cfg.Mail.SMTP.PasswordFile, "smtp password"); err != nil {
+		return nil, err
+	}
+
+	cfg.Auth.URL = strings.TrimRight(cfg.Auth.URL, "/")
+	if cfg.Auth.JWKSURL == "" && cfg.Auth.URL != "" {
+		cfg.Auth.JWKSURL = cfg.Auth.URL + "/.well-known/jwks.json"
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.WebPort == "" {
+		problems = append(problems, "web_port is required")
+	}
+	if c.Database.DSN == "" {
+		problems = append(problems, "database.dsn is required")
+	}
+	if !isHTTPURL(c.Auth.URL) {
+		problems = append(problems, fmt.Sprintf("auth.url must be an http(s) URL, got %q", c.Auth.URL))
+	}
+	if !isHTTPURL(c.Auth.JWKSURL) {
+		problems = append(problems, fmt.Sprintf("auth.jwks_url must be an http(s) URL, got %q", c.Auth.JWKSURL))
+	}
+	if c.Database.QueryTimeout <= 0 {
+		problems = append(problems, "database.query_timeout must be positive")
+	}
+	if c.Database.MaxConns < 1 {
+		problems = append(problems, "database.max_conns must be positive")
+	}
+	if c.Database.MinConns < 0 || c.Database.MinConns > c.Database.MaxConns {
+		problems = append(problems, "database.min_conns must be between 0 and database.max_conns")
+	}
+	if c.Database.MaxConnLifetime <= 0 {
+		problems = append(problems, "database.max_conn_lifetime must be positive")
+	}
+	if c.Database.MaxConnIdleTime <= 0 {
+		problems = append(problems, "database.max_conn_idle_time must be positive")
+	}
+	if c.Database.HealthCheckPeriod <= 0 {
+		problems = append(problems, "database.health_check_period must be positive")
+	}
+	if c.Auth.ServiceKey == "" {
+		problems = append(problems, "auth.service_key is required")
+	}
+	if c.Auth.Timeout <= 0 {
+		problems = append(problems, "auth.timeout must be positive")
+	}
+	if c.Auth.Retries < 0 {
+		problems = append(problems, "auth.retries must not be negative")
+	}
+	if c.Auth.BreakerThreshold < 0 {
+		problems = append(problems, "auth.breaker_threshold must not be negative")
+	}
+	if c.Auth.BreakerCooldown <= 0 {
+		problems = append(problems, "auth.breaker_cooldown must be positive")
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		problems = append(problems, "auth.access_token_ttl must be positive")
+	}
+	if c.MFA.Issuer == "" {
+		problems = append(problems, "mfa.issuer is required")
+	}
+	if c.MFA.ChallengeTTL <= 0 {
+		problems = append(problems, "mfa.challenge_ttl must be positive")
+	}
+
+	if c.Cookies.SigningKey == "" {
+		problems = append(problems, "cookies.signing_key is required")
+	}
+	switch c.Cookies.SameSite {
+	case "lax", "strict":
+	case "none":
+		if !c.Cookies.Secure {
+			problems = append(problems, "cookies.same_site none requires cookies.secure")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cookies.same_site must be lax, strict or none, got %q", c.Cookies.SameSite))
+	}
+	switch c.Mail.Driver {
+	case "log":
+	case "file":
+		if c.Mail.Dir == "" {
+			problems = append(problems, "mail.dir is required by the file driver")
+		}
+	case "smtp":
+		if _, _, err := net.SplitHostPort(c.Mail.SMTP.Addr); err != nil {
+			problems = append(problems, fmt.Sprintf("mail.smtp.addr must be host:port, got %q", c.Mail.SMTP.Addr))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("mail.driver must be smtp, log or file, got %q", c.Mail.Driver))
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		problems = append(problems, fmt.Sprintf("mail.from must be an email address, got %q", c.Mail.From))
+	}
+	if !isHTTPURL(c.Verification.URL) {
+		problems = append(problems, fmt.Sprintf("verification.url must be an http(s) URL, got %q", c.Verification.URL))
+	}
+	if c.Verification.TokenTTL <= 0 {
+		problems = append(problems, "verification.token_ttl must be positive")
+	}
+	if c.Verification.ResendInterval < 0 {
+		problems = append(problems, "verification.resend_interval must not be negative")
+	}
+	if !isHTTPURL(c.PasswordReset.URL) {
+		problems = append(problems, fmt.Sprintf("password_reset.url must be an http(s) URL, got %q", c.PasswordReset.URL))
+	}
+	if c.PasswordReset.TokenTTL <= 0 {
+		problems = append(problems, "password_reset.token_ttl must be positive")
+	}
+	if c.PasswordReset.ResendInterval < 0 {
+		problems = append(problems, "password_reset.resend_interval must not be negative")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if !isOrigin(origin) {
+			problems = append(problems, fmt.Sprintf("cors.allowed_origins must be scheme://host[:port], got %q", origin))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the settings with secrets hidden, safe to print.
+// The whole DSN is hidden since it carries the database password.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if out.Database.DSN != "" {
+		out.Database.DSN = redacted
+	}
+	if out.AdminAPIKey != "" {
+		out.AdminAPIKey = redacted
+	}
+	if out.Auth.ServiceKey != "" {
+		out.Auth.ServiceKey = redacted
+	}
+	if out.Cookies.SigningKey != "" {
+		out.Cookies.SigningKey = redacted
+	}
+	if out.Cookies.EncryptionKey != "" {
+		out.Cookies.EncryptionKey = redacted
+	}
+	if out.Mail.SMTP.Password != "" {
+		out.Mail.SMTP.Password = redacted
+	}
+
+	return &out
+}
+
+// YAML renders the settings in the format Load reads.
+func (c *Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) readEnv() error {
+	var errs []string
+	setString(&c.WebPort, "WEB_PORT")
+	setString(&c.Database.DSN, "DSN")
+	setString(&c.Database.DSNFile, "DSN_FILE")
+	setBool(&c.Database.MigrateOnStart, "DB_MIGRATE_ON_START", &errs)
+	setDuration(&c.Database.QueryTimeout, "DB_QUERY_TIMEOUT", &errs)
+	setInt(&c.Database.MaxConns, "DB_MAX_CONNS", &errs)
+	setInt(&c.Database.MinConns, "DB_MIN_CONNS", &errs)
+	setDuration(&c.Database.MaxConnLifetime, "DB_MAX_CONN_LIFETIME", &errs)
+	setDuration(&c.Database.MaxConnIdleTime, "DB_MAX_CONN_IDLE_TIME", &errs)
+	setDuration(&c.Database.HealthCheckPeriod, "DB_HEALTH_CHECK_PERIOD", &errs)
+	setString(&c.Auth.URL, "AUTH_SERVICE_URL")
+	setString(&c.Auth.JWKSURL, "JWKS_URL")
+	setDuration(&c.Auth.Timeout, "AUTH_SERVICE_TIMEOUT", &errs)
+	setInt(&c.Auth.Retries, "AUTH_SERVICE_RETRIES", &errs)
+	setInt(&c.Auth.BreakerThreshold, "AUTH_BREAKER_THRESHOLD", &errs)
+	setDuration(&c.Auth.BreakerCooldown, "AUTH_BREAKER_COOLDOWN", &errs)
+	setDuration(&c.Auth.AccessTokenTTL, "AUTH_ACCESS_TOKEN_TTL", &errs)
+	setString(&c.Auth.ServiceKey, "AUTH_SERVICE_KEY")
+	setString(&c.Auth.ServiceKeyFile, "AUTH_SERVICE_KEY_FILE")
+	setString(&c.MFA.Issuer, "MFA_ISSUER")
+	setDuration(&c.MFA.ChallengeTTL, "MFA_CHALLENGE_TTL", &errs)
+	setString(&c.AdminAPIKey, "ADMIN_API_KEY")
+	setString(&c.AdminAPIKeyFile, "ADMIN_API_KEY_FILE")
+	setBool(&c.Cookies.Secure, "COOKIE_SECURE", &errs)
+	setString(&c.Cookies.SameSite, "COOKIE_SAME_SITE")
+	setString(&c.Cookies.Domain, "COOKIE_DOMAIN")
+	setString(&c.Cookies.SigningKey, "COOKIE_SIGNING_KEY")
+	setString(&c.Cookies.SigningKeyFile, "COOKIE_SIGNING_KEY_FILE")
+	setString(&c.Cookies.EncryptionKey, "COOKIE_ENCRYPTION_KEY")
+	setString(&c.Cookies.EncryptionKeyFile, "COOKIE_ENCRYPTION_KEY_FILE")
+	setList(&c.CORS.AllowedOrigins, "CORS_ALLOWED_ORIGINS")
+	setString(&c.Mail.Driver, "MAIL_DRIVER")
+	setString(&c.Mail.From, "MAIL_FROM")
+	setString(&c.Mail.Dir, "MAIL_DIR")
+	setString(&c.Mail.SMTP.Addr, "SMTP_ADDR")
+	setString(&c.Mail.SMTP.Username, "SMTP_USERNAME")
+	setString(&c.Mail.SMTP.Password, "SMTP_PASSWORD")
+	setString(&c.Mail.SMTP.PasswordFile, "SMTP_PASSWORD_FILE")
+	setString(&c.Verification.URL, "VERIFICATION_URL")
+	setDuration(&c.Verification.TokenTTL, "VERIFICATION_TOKEN_TTL", &errs)
+	setDuration(&c.Verification.ResendInterval, "VERIFICATION_RESEND_INTERVAL", &errs)
+	setString(&c.PasswordReset.URL, "PASSWORD_RESET_URL")
+	setDuration(&c.PasswordReset.TokenTTL, "PASSWORD_RESET_TOKEN_TTL", &errs)
+	setDuration(&c.PasswordReset.ResendInterval, "PASSWORD_RESET_RESEND_INTERVAL", &errs)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// readSecretFile loads the secret stored in file into value. Setting both the
+// secret and its file is ambiguous and refused.
+func readSecretFile(value *string, file string, name string) error {
+	if file == "" {
+		return nil
+	}
+	if *value != "" {
+		return fmt.Errorf("both the %s and its file are set, use one", name)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading %s file: %w", name, err)
+	}
+	*value = strings.TrimRight(string(content), "\r\n")
+
+	return nil
+}
+
+func setString(target *string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+// setList reads a comma separated list, such as
+// https://app.example.com,https://admin.example.com.
+func setList(target *[]string, name string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*target = list
+}
+
+func setBool(target *bool, name string, errs *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s is not a boolean: %q", name, value))
+		return
+	}
+	*target = b
+}
+
+func setInt(target *int, name string, errs *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s is not a number: %q", name, value))
+		return
+	}
+	*target = n
+}
+
+func setDuration(target *time.Duration, name string, errs *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s is not a duration: %q", name, value))
+		return
+	}
+	*target = d
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isOrigin reports whether raw is an exact web origin, with no wildcard, path
+// or trailing slash.
+func isOrigin(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && isHTTPURL(raw) && u.Path == "" && u.RawQuery == "" && !strings.Contains(u.Host, "*")
+}
@@ -0,0 +1,396 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// RecoveryCodeCount is how many recovery codes a user gets at once.
+	RecoveryCodeCount = 10
+	// recoveryCodeBytes gives 80 bits per code, printed as xxxx-xxxx-xxxx-xxxx.
+	recoveryCodeBytes = 10
+	challengeBytes    = 32
+)
+
+var (
+	ErrMFANotEnrolled      = errors.New("two-factor authentication is not set up")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrChallengeNotFound   = errors.New("invalid or expired challenge")
+	ErrCodeAlreadyUsed     = errors.New("code already used")
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+)
+
+// MFA is the TOTP second factor of a user. It is pending from enrolment
+// until the user proves their authenticator works with a first code.
+type MFA struct {
+	UserID       int          `json:"user_id"`
+	Secret       string       `json:"-"`
+	Enabled      bool         `json:"enabled"`
+	LastUsedStep int64        `json:"-"`
+	CreatedAt    time.Time    `json:"created_at"`
+	ConfirmedAt  sql.NullTime `json:"-"`
+}
+
+// MFARepository keeps the second factors of the users, their recovery codes
+// and the challenges of logins waiting for a code. Challenges and recovery
+// codes are looked up by their plaintext and stored hashed.
+type MFARepository interface {
+	// GetByUserID fails with ErrMFANotEnrolled when the user has none.
+	GetByUserID(ctx context.Context, userID int) (*MFA, error)
+	IsEnabled(ctx context.Context, userID int) (bool, error)
+	Enroll(ctx context.Context, userID int, secret string) error
+	Enable(ctx context.Context, userID int, step int64, codeHashes []string) error
+	UseStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, code string) error
+	RemainingRecoveryCodes(ctx context.Context, userID int) (int, error)
+	Reset(ctx context.Context, userID int) error
+	CreateChallenge(ctx context.Context, userID int, device string, ttl time.Duration) (string, error)
+	// GetChallenge, FailChallenge and ConsumeChallenge take the plaintext
+	// token handed out by CreateChallenge.
+	GetChallenge(ctx context.Context, token string) (*MFAChallenge, error)
+	FailChallenge(ctx context.Context, token string, maxAttempts int) error
+	ConsumeChallenge(ctx context.Context, token string) error
+}
+
+// PostgresMFARepository stores the second factors in the user_mfa,
+// user_recovery_codes and mfa_challenges tables. Each query is bounded by
+// timeout, on top of the deadline of its context.
+type PostgresMFARepository struct {
+	db      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewPostgresMFARepository(db *pgxpool.Pool, timeout time.Duration) *PostgresMFARepository {
+	return &PostgresMFARepository{db: db, timeout: timeout}
+}
+
+// MFAChallenge is handed out by a login whose password matched when the user
+// has two-factor authentication on. The session is only created once a code
+// is presented with it.
+type MFAChallenge struct {
+	UserID    int
+	Device    string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+// GetByUserID returns the second factor of the user id.
+func (m *PostgresMFARepository) GetByUserID(ctx context.Context, userID int) (*MFA, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `select user_id, secret, enabled, last_used_step, created_at, confirmed_at from user_mfa where user_id = $1`
+
+	var mfa MFA
+	err := m.db.QueryRow(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.Enabled,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+		&mfa.ConfirmedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// IsEnabled reports whether the user id has to present a second factor on login.
+func (m *PostgresMFARepository) IsEnabled(ctx context.Context, userID int) (bool, error) {
+	mfa, err := m.GetByUserID(ctx, userID)
+	if errors.Is(err, ErrMFANotEnrolled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return mfa.Enabled, nil
+}
+
+// Enroll stores secret as the pending second factor of the user id, replacing
+// a pending one. It fails once the second factor is enabled.
+func (m *PostgresMFARepository) Enroll(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `insert into user_mfa (user_id, secret, enabled, last_used_step, created_at)
+		values ($1, $2, false, 0, $3)
+		on conflict (user_id) do update set secret = $2, last_used_step = 0, created_at = $3
+		where user_mfa.enabled = false`
+
+	result, err := m.db.Exec(ctx, query, userID, secret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// Enable turns on the pending second factor of the user id, recording step as
+// used, and replaces its recovery codes with the ones hashed in codeHashes.
+func (m *PostgresMFARepository) Enable(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `update user_mfa set enabled = true, last_used_step = $2, confirmed_at = $3
+		where user_id = $1 and enabled = false`
+
+	result, err := tx.Exec(ctx, query, userID, step, time.Now())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseStep records that the code of step was accepted for the user id. Codes of
+// that step or earlier are refused afterwards, so a code works only once.
+func (m *PostgresMFARepository) UseStep(ctx context.Context, userID int, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `update user_mfa set last_used_step = $2 where user_id = $1 and last_used_step < $2`
+
+	result, err := m.db.Exec(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrCodeAlreadyUsed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode spends the recovery code of the user id.
+func (m *PostgresMFARepository) UseRecoveryCode(ctx context.Context, userID int, code string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `update user_recovery_codes set used_at = $3
+		where user_id = $1 and code_hash = $2 and used_at is null`
+
+	result, err := m.db.Exec(ctx, query, userID, hashRecoveryCode(code), time.Now())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInvalidRecoveryCode
+	}
+
+	return nil
+}
+
+// RemainingRecoveryCodes returns how many recovery codes of the user id are
+// still unused.
+func (m *PostgresMFARepository) RemainingRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `select count(*) from user_recovery_codes where user_id = $1 and used_at is null`
+
+	var count int
+	err := m.db.QueryRow(ctx, query, userID).Scan(&count)
+
+	return count, err
+}
+
+// Reset removes the second factor of the user id with its recovery codes and
+// pending challenges, so the user logs in with a password alone again.
+func (m *PostgresMFARepository) Reset(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, query := range []string{
+		`delete from user_recovery_codes where user_id = $1`,
+		`delete from mfa_challenges where user_id = $1`,
+		`delete from user_mfa where user_id = $1`,
+	} {
+		if _, err = tx.Exec(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// CreateChallenge starts the second step of a login of the user id and
+// returns the plaintext challenge token, valid for ttl.
+func (m *PostgresMFARepository) CreateChallenge(ctx context.Context, userID int, device string, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	b := make([]byte, challengeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	// drop abandoned challenges while we are at it
+	_, err := m.db.Exec(ctx, `delete from mfa_challenges where expires_at < $1`, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	query := `insert into mfa_challenges (token_hash, user_id, device, attempts, expires_at, created_at)
+		values ($1, $2, $3, 0, $4, $5)`
+
+	now := time.Now()
+	_, err = m.db.Exec(ctx, query, hashChallenge(token), userID, device, now.Add(ttl), now)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetChallenge returns the live challenge of token.
+func (m *PostgresMFARepository) GetChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	query := `select user_id, device, attempts, expires_at from mfa_challenges
+		where token_hash = $1 and expires_at > $2`
+
+	var challenge MFAChallenge
+	err := m.db.QueryRow(ctx, query, hashChallenge(token), time.Now()).Scan(
+		&challenge.UserID,
+		&challenge.Device,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// FailChallenge counts a wrong code presented with token and drops the
+// challenge once maxAttempts were wasted on it.
+func (m *PostgresMFARepository) FailChallenge(ctx context.Context, token string, maxAttempts int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	hash := hashChallenge(token)
+	_, err := m.db.Exec(ctx, `update mfa_challenges set attempts = attempts + 1 where token_hash = $1`, hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Exec(ctx, `delete from mfa_challenges where token_hash = $1 and attempts >= $2`, hash, maxAttempts)
+
+	return err
+}
+
+// ConsumeChallenge deletes the challenge of token, failing when another
+// request got to it first.
+func (m *PostgresMFARepository) ConsumeChallenge(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	result, err := m.db.Exec(ctx, `delete from mfa_challenges where token_hash = $1 and expires_at > $2`, hashChallenge(token), time.Now())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrChallengeNotFound
+	}
+
+	return nil
+}
+
+// NewRecoveryCodes returns RecoveryCodeCount fresh recovery codes together
+// with the hashes to store for them.
+func NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int, codeHashes []string) error {
+	_, err := tx.Exec(ctx, `delete from user_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `insert into user_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+	for _, hash := range codeHashes {
+		if _, err = tx.Exec(ctx, query, userID, hash, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case, spaces and
+// dashes. The codes are random enough for a plain SHA-256.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hashChallenge(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MemoryMFARepository keeps the second factors in memory, for tests and
+// running without a database. Like a database, it fails once ctx is done.
+type MemoryMFARepository struct {
+	mu      sync.Mutex
+	factors map[int]MFA
+	// recoveryCodes maps the hashes of the codes of each user to whether
+	// they were used.
+	recoveryCodes map[int]map[string]bool
+	// challenges are keyed by the hash of their token.
+	challenges map[string]MFAChallenge
+}
+
+func NewMemoryMFARepository() *MemoryMFARepository {
+	return &MemoryMFARepository{
+		factors:       map[int]MFA{},
+		recoveryCodes: map[int]map[string]bool{},
+		challenges:    map[string]MFAChallenge{},
+	}
+}
+
+func (m *MemoryMFARepository) GetByUserID(ctx context.Context, userID int) (*MFA, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.factors[userID]
+	if !ok {
+		return nil, ErrMFANotEnrolled
+	}
+
+	return &mfa, nil
+}
+
+func (m *MemoryMFARepository) IsEnabled(ctx context.Context, userID int) (bool, error) {
+	mfa, err := m.GetByUserID(ctx, userID)
+	if errors.Is(err, ErrMFANotEnrolled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return mfa.Enabled, nil
+}
+
+func (m *MemoryMFARepository) Enroll(ctx context.Context, userID int, secret string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.factors[userID].Enabled {
+		return ErrMFAAlreadyEnabled
+	}
+	m.factors[userID] = MFA{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+
+	return nil
+}
+
+func (m *MemoryMFARepository) Enable(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.factors[userID]
+	if !ok || mfa.Enabled {
+		return ErrMFAAlreadyEnabled
+	}
+	mfa.Enabled = true
+	mfa.LastUsedStep = step
+	mfa.ConfirmedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	m.factors[userID] = mfa
+
+	codes := map[string]bool{}
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	m.recoveryCodes[userID] = codes
+
+	return nil
+}
+
+func (m *MemoryMFARepository) UseStep(ctx context.Context, userID int, step int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.factors[userID]
+	if !ok || mfa.LastUsedStep >= step {
+		return ErrCodeAlreadyUsed
+	}
+	mfa.LastUsedStep = step
+	m.factors[userID] = mfa
+
+	return nil
+}
+
+func (m *MemoryMFARepository) UseRecoveryCode(ctx context.Context, userID int, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := hashRecoveryCode(code)
+	used, ok := m.recoveryCodes[userID][hash]
+	if !ok || used {
+		return ErrInvalidRecoveryCode
+	}
+	m.recoveryCodes[userID][hash] = true
+
+	return nil
+}
+
+func (m *MemoryMFARepository) RemainingRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, used := range m.recoveryCodes[userID] {
+		if !used {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *MemoryMFARepository) Reset(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.factors, userID)
+	delete(m.recoveryCodes, userID)
+	for hash, challenge := range m.challenges {
+		if challenge.UserID == userID {
+			delete(m.challenges, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryMFARepository) CreateChallenge(ctx context.Context, userID int, device string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, challengeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.challenges[hashChallenge(token)] = MFAChallenge{
+		UserID:    userID,
+		Device:    device,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	return token, nil
+}
+
+func (m *MemoryMFARepository) GetChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	challenge, ok := m.challenges[hashChallenge(token)]
+	if !ok || !challenge.ExpiresAt.After(time.Now()) {
+		return nil, ErrChallengeNotFound
+	}
+
+	return &challenge, nil
+}
+
+func (m *MemoryMFARepository) FailChallenge(ctx context.Context, token string, maxAttempts int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := hashChallenge(token)
+	challenge, ok := m.challenges[hash]
+	if !ok {
+		return nil
+	}
+
+	challenge.Attempts++
+	if challenge.Attempts >= maxAttempts {
+		delete(m.challenges, hash)
+	} else {
+		m.challenges[hash] = challenge
+	}
+
+	return nil
+}
+
+func (m *MemoryMFARepository) ConsumeChallenge(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := hashChallenge(token)
+	challenge, ok := m.challenges[hash]
+	if !ok || !challenge.ExpiresAt.After(time.Now()) {
+		return ErrChallengeNotFound
+	}
+	delete(m.challenges, hash)
+
+	return nil
+}
@@ -1,21 +1,26 @@
 package data
 
 import (
-	"context"
-	"database/sql"
 	"errors"
-	"log"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v4/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
-const dbTimeout = time.Second * 3
-
-var db *sql.DB
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrDuplicateEmail = errors.New("email already registered")
+)
 
 type Models struct {
-	User User
+	User                UserRepository
+	MFA                 MFARepository
+	PersonalAccessToken PersonalAccessTokenRepository
+	Role                RoleRepository
+	EmailVerification   EmailVerificationRepository
+	PasswordReset       PasswordResetRepository
 }
 
 type User struct {
@@ -29,202 +34,17 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-func New(dbPool *sql.DB) Models {
-	db = dbPool
-
+// New returns the stores of dbPool, whose queries each give up after
+// timeout.
+func New(dbPool *pgxpool.Pool, timeout time.Duration) Models {
 	return Models{
-		User: User{},
-	}
-}
-
-func (u *User) GetAll() ([]*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `select id, email, first_name, last_name, password, status, created_at, updated_at
-	from users order by updated_at
-	`
-
-	rows, err := db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []*User
-
-	for rows.Next() {
-		var user User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Password,
-			&user.Status,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
-		if err != nil {
-			log.Printf("Error scanning", err)
-			return nil, err
-		}
-
-		users = append(users, &user)
-	}
-
-	return users, nil
-}
-
-func (u *User) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `select id, email, first_name, last_name, password, status, created_at, updated_at from users where email = $1`
-
-	var user User
-	row := db.QueryRowContext(ctx, query, email)
-
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.Status,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
-}
-
-func (u *User) Get(id int) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `select id, email, first_name, last_name, password, status, created_at, updated_at from users where id = $1`
-
-	var user User
-	row := db.QueryRowContext(ctx, query, id)
-
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.Status,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
-}
-
-func (u *User) Update() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `update users set
-		email = $1,
-		first_name = $2,
-		last_name = $3,
-		status = $4,
-		updated_at = $5
-		where id = $6
-	`
-
-	_, err := db.ExecContext(ctx, query, u.Email, u.FirstName, u.LastName, u.Status, time.Now(), u.ID)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (u *User) Delete() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `delete from users where id = $1`
-
-	_, err := db.ExecContext(ctx, query, u.ID)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (u *User) DeleteByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `delete from users where id = $1`
-
-	_, err := db.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (u *User) Insert(user User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
-	if err != nil {
-		return 0, err
+		User:                NewPostgresUserRepository(dbPool, timeout),
+		MFA:                 NewPostgresMFARepository(dbPool, timeout),
+		PersonalAccessToken: NewPostgresPersonalAccessTokenRepository(dbPool, timeout),
+		Role:                NewPostgresRoleRepository(dbPool, timeout),
+		EmailVerification:   NewPostgresEmailVerificationRepository(dbPool, timeout),
+		PasswordReset:       NewPostgresPasswordResetRepository(dbPool, timeout),
 	}
-
-	var newID int
-	query := `insert into users (email, first_name, last_name, password, status, created_at, updated_at)
-		values($1, $2, $3, $4, $5, $6, $7) returning id`
-
-	err = db.QueryRowContext(
-		ctx,
-		query,
-		user.Email,
-		user.FirstName,
-		user.LastName,
-		hashedPassword,
-		user.Status,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
-
-	if err != nil {
-		return 0, err
-	}
-
-	return newID, nil
-}
-
-func (u *User) ResetPassword(password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	hashPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	if err != nil {
-		return err
-	}
-
-	query := `update user set password id = $2`
-	_, err = db.ExecContext(ctx, query, hashPassword, u.ID)
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 func (u *User) PasswordMatches(plaintext string) (bool, error) {
@@ -240,3 +60,12 @@ func (u *User) PasswordMatches(plaintext string) (bool, error) {
 
 	return true, nil
 }
+
+// NormalizeEmail returns the form emails are stored and looked up in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), 12)
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const passwordResetTokenBytes = 32
+
+var ErrPasswordResetNotFound = errors.New("invalid or expired password reset token")
+
+// PasswordResetRepository holds the tokens mailed to users who forgot their
+// password. Only a hash of each token is stored, and a token works once.
+type PasswordResetRepository interface {
+	Create(ctx context.Context, userID int, ttl time.Duration) (string, error)
+	LastSent(ctx context.Context, userID int) (time.Time, error)
+	// Consume fails with ErrPasswordResetNotFound when token is unknown or
+	// expired.
+	Consume(ctx context.Context, token string) (int, error)
+}
+
+// PostgresPasswordResetRepository stores the tokens in the password_resets
+// table. Each query is bounded by timeout, on top of the deadline of its
+// context.
+type PostgresPasswordResetRepository struct {
+	db      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewPostgresPasswordResetRepository(db *pgxpool.Pool, timeout time.Duration) *PostgresPasswordResetRepository {
+	return &PostgresPasswordResetRepository{db: db, timeout: timeout}
+}
+
+// Create issues a password reset token for the user id, valid for ttl, and
+// returns its plaintext.
+func (p *PostgresPasswordResetRepository) Create(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	b := make([]byte, passwordResetTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	// drop expired tokens while we are at it
+	_, err := p.db.Exec(ctx, `delete from password_resets where expires_at < $1`, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	query := `insert into password_resets (token_hash, user_id, expires_at, created_at)
+		values ($1, $2, $3, $4)`
+
+	now := time.Now()
+	_, err = p.db.Exec(ctx, query, hashChallenge(token), userID, now.Add(ttl), now)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// LastSent returns when the latest token of the user id was issued, or the
+// zero time when none is live.
+func (p *PostgresPasswordResetRepository) LastSent(ctx context.Context, userID int) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	query := `select max(created_at) from password_resets where user_id = $1 and expires_at > $2`
+
+	var last sql.NullTime
+	err := p.db.QueryRow(ctx, query, userID, time.Now()).Scan(&last)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return last.Time, nil
+}
+
+// Consume uses up token, with the other tokens of the user it was issued to,
+// and returns the id of that user, whose password the caller then sets.
+func (p *PostgresPasswordResetRepository) Consume(ctx context.Context, token string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	query := `delete from password_resets where token_hash = $1 and expires_at > $2 returning user_id`
+	err = tx.QueryRow(ctx, query, hashChallenge(token), time.Now()).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrPasswordResetNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `delete from password_resets where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
@@ -0,0 +1,29 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// MemoryPasswordResetRepository keeps the password reset tokens in memory,
+// for tests and running without a database. Like a database, it fails once
+// ctx is done.
+type MemoryPasswordResetRepository struct {
+	tokens memoryTokens
+}
+
+func NewMemoryPasswordResetRepository() *MemoryPasswordResetRepository {
+	return &MemoryPasswordResetRepository{}
+}
+
+func (p *MemoryPasswordResetRepository) Create(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	return p.tokens.create(ctx, userID, ttl, passwordResetTokenBytes)
+}
+
+func (p *MemoryPasswordResetRepository) LastSent(ctx context.Context, userID int) (time.Time, error) {
+	return p.tokens.lastSent(ctx, userID)
+}
+
+func (p *MemoryPasswordResetRepository) Consume(ctx context.Context, token string) (int, error) {
+	return p.tokens.consume(ctx, token, ErrPasswordResetNotFound)
+}
@@ -0,0 +1,244 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Role audit actions.
+const (
+	RoleGranted = "grant"
+	RoleRevoked = "revoke"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleAlreadyGranted = errors.New("user already has the role")
+	ErrRoleNotGranted     = errors.New("user does not have the role")
+)
+
+// Role groups permissions granted to users together.
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleRepository keeps the roles, who they are granted to and the audit log
+// of those grants. Granting or revoking a role that does not exist fails with
+// ErrRoleNotFound.
+type RoleRepository interface {
+	GetAll(ctx context.Context) ([]*Role, error)
+	GetForUser(ctx context.Context, userID int) ([]string, []string, error)
+	Grant(ctx context.Context, actor string, userID int, role string) error
+	Revoke(ctx context.Context, actor string, userID int, role string) error
+	AuditLog(ctx context.Context, userID int) ([]*RoleAudit, error)
+}
+
+// PostgresRoleRepository stores the roles in the roles, role_permissions,
+// user_roles and role_audit_log tables. Each query is bounded by timeout, on
+// top of the deadline of its context.
+type PostgresRoleRepository struct {
+	db      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewPostgresRoleRepository(db *pgxpool.Pool, timeout time.Duration) *PostgresRoleRepository {
+	return &PostgresRoleRepository{db: db, timeout: timeout}
+}
+
+// RoleAudit records a role granted to or revoked from a user, and by whom.
+type RoleAudit struct {
+	ID        int       `json:"id"`
+	Actor     string    `json:"actor"`
+	UserID    int       `json:"user_id"`
+	Role      string    `json:"role"`
+	Action    string    `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetAll returns every role with its permissions.
+func (r *PostgresRoleRepository) GetAll(ctx context.Context) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	query := `select r.id, r.name, coalesce(r.description, ''), p.name
+	from roles r
+	left join role_permissions rp on rp.role_id = r.id
+	left join permissions p on p.id = rp.permission_id
+	order by r.name, p.name`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	var current *Role
+
+	for rows.Next() {
+		var role Role
+		var permission sql.NullString
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &permission); err != nil {
+			return nil, err
+		}
+
+		if current == nil || current.ID != role.ID {
+			role.Permissions = []string{}
+			current = &role
+			roles = append(roles, current)
+		}
+		if permission.Valid {
+			current.Permissions = append(current.Permissions, permission.String)
+		}
+	}
+
+	return roles, rows.Err()
+}
+
+// GetForUser returns the names of the roles of the user id and the
+// permissions they add up to.
+func (r *PostgresRoleRepository) GetForUser(ctx context.Context, userID int) ([]string, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	query := `select r.name, p.name
+	from user_roles ur
+	join roles r on r.id = ur.role_id
+	left join role_permissions rp on rp.role_id = r.id
+	left join permissions p on p.id = rp.permission_id
+	where ur.user_id = $1
+	order by r.name, p.name`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	permissions := []string{}
+	seen := map[string]bool{}
+
+	for rows.Next() {
+		var role string
+		var permission sql.NullString
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, nil, err
+		}
+
+		if len(roles) == 0 || roles[len(roles)-1] != role {
+			roles = append(roles, role)
+		}
+		if permission.Valid && !seen[permission.String] {
+			seen[permission.String] = true
+			permissions = append(permissions, permission.String)
+		}
+	}
+
+	return roles, permissions, rows.Err()
+}
+
+// Grant gives role to the user id, recording actor in the audit log.
+func (r *PostgresRoleRepository) Grant(ctx context.Context, actor string, userID int, role string) error {
+	query := `insert into user_roles (user_id, role_id, granted_at)
+		select $1, id, $3 from roles where name = $2
+		on conflict do nothing`
+
+	return r.change(ctx, actor, userID, role, RoleGranted, ErrRoleAlreadyGranted, query, userID, role, time.Now())
+}
+
+// Revoke takes role away from the user id, recording actor in the audit log.
+func (r *PostgresRoleRepository) Revoke(ctx context.Context, actor string, userID int, role string) error {
+	query := `delete from user_roles where user_id = $1 and role_id = (select id from roles where name = $2)`
+
+	return r.change(ctx, actor, userID, role, RoleRevoked, ErrRoleNotGranted, query, userID, role)
+}
+
+// AuditLog returns the role changes of the user id, latest first.
+func (r *PostgresRoleRepository) AuditLog(ctx context.Context, userID int) ([]*RoleAudit, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	query := `select id, actor, user_id, role, action, created_at
+	from role_audit_log where user_id = $1 order by created_at desc, id desc`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*RoleAudit{}
+
+	for rows.Next() {
+		var entry RoleAudit
+		err := rows.Scan(
+			&entry.ID,
+			&entry.Actor,
+			&entry.UserID,
+			&entry.Role,
+			&entry.Action,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// change runs query with args, granting or revoking role, and writes the
+// audit entry in the same transaction. unchanged is returned when query
+// affected nothing.
+func (r *PostgresRoleRepository) change(ctx context.Context, actor string, userID int, role string, action string, unchanged error, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `select exists (select 1 from roles where name = $1)`, role).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRoleNotFound
+	}
+
+	result, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return unchanged
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`insert into role_audit_log (actor, user_id, role, action, created_at) values ($1, $2, $3, $4, $5)`,
+		actor,
+		userID,
+		role,
+		action,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRoleRepository keeps the roles and their grants in memory, for tests
+// and running without a database. The roles themselves are fixed when it is
+// created. Like a database, it fails once ctx is done.
+type MemoryRoleRepository struct {
+	mu    sync.Mutex
+	roles map[string]Role
+	// grants maps the users to the names of their roles.
+	grants map[int]map[string]bool
+	audit  []RoleAudit
+}
+
+func NewMemoryRoleRepository(roles ...Role) *MemoryRoleRepository {
+	r := &MemoryRoleRepository{roles: map[string]Role{}, grants: map[int]map[string]bool{}}
+	for i, role := range roles {
+		role.ID = i + 1
+		role.Permissions = append([]string{}, role.Permissions...)
+		sort.Strings(role.Permissions)
+		r.roles[role.Name] = role
+	}
+
+	return r
+}
+
+func (r *MemoryRoleRepository) GetAll(ctx context.Context) ([]*Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roles := []*Role{}
+	for _, role := range r.roles {
+		role := role
+		role.Permissions = append([]string{}, role.Permissions...)
+		roles = append(roles, &role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+
+	return roles, nil
+}
+
+func (r *MemoryRoleRepository) GetForUser(ctx context.Context, userID int) ([]string, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roles := []string{}
+	for name := range r.grants[userID] {
+		roles = append(roles, name)
+	}
+	sort.Strings(roles)
+
+	permissions := []string{}
+	seen := map[string]bool{}
+	for _, name := range roles {
+		for _, permission := range r.roles[name].Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	return roles, permissions, nil
+}
+
+func (r *MemoryRoleRepository) Grant(ctx context.Context, actor string, userID int, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.roles[role]; !ok {
+		return ErrRoleNotFound
+	}
+	if r.grants[userID][role] {
+		return ErrRoleAlreadyGranted
+	}
+
+	if r.grants[userID] == nil {
+		r.grants[userID] = map[string]bool{}
+	}
+	r.grants[userID][role] = true
+	r.record(actor, userID, role, RoleGranted)
+
+	return nil
+}
+
+func (r *MemoryRoleRepository) Revoke(ctx context.Context, actor string, userID int, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.roles[role]; !ok {
+		return ErrRoleNotFound
+	}
+	if !r.grants[userID][role] {
+		return ErrRoleNotGranted
+	}
+
+	delete(r.grants[userID], role)
+	r.record(actor, userID, role, RoleRevoked)
+
+	return nil
+}
+
+func (r *MemoryRoleRepository) AuditLog(ctx context.Context, userID int) ([]*RoleAudit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := []*RoleAudit{}
+	for i := len(r.audit) - 1; i >= 0; i-- {
+		if r.audit[i].UserID == userID {
+			entry := r.audit[i]
+			entries = append(entries, &entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// record appends an entry to the audit log.
+func (r *MemoryRoleRepository) record(actor string, userID int, role string, action string) {
+	r.audit = append(r.audit, RoleAudit{
+		ID:        len(r.audit) + 1,
+		Actor:     actor,
+		UserID:    userID,
+		Role:      role,
+		Action:    action,
+		CreatedAt: time.Now(),
+	})
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token, telling them
+// apart from access tokens and making leaked ones easy to scan for.
+const PersonalAccessTokenPrefix = "pat_"
+
+const (
+	personalAccessTokenBytes = 32
+	// lastUsedResolution limits how often a token is rewritten just to bump
+	// its last used time.
+	lastUsedResolution = time.Minute
+)
+
+var ErrTokenNotFound = errors.New("invalid or expired token")
+
+// PersonalAccessToken lets scripts and bots call the API as a user without a
+// browser session, limited to the scopes chosen when it was created. Only a
+// hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Email      string     `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// PersonalAccessTokenRepository keeps the personal access tokens. Lookups of
+// a missing or expired token fail with ErrTokenNotFound.
+type PersonalAccessTokenRepository interface {
+	Insert(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (string, *PersonalAccessToken, error)
+	GetAllByUserID(ctx context.Context, userID int) ([]*PersonalAccessToken, error)
+	Authenticate(ctx context.Context, plaintext string) (*PersonalAccessToken, error)
+	Revoke(ctx context.Context, userID int, id int) error
+	// RevokeAll revokes every token of the user and returns how many there
+	// were.
+	RevokeAll(ctx context.Context, userID int) (int, error)
+}
+
+// PostgresPersonalAccessTokenRepository stores the personal access tokens in
+// the personal_access_tokens table. Each query is bounded by timeout, on top
+// of the deadline of its context.
+type PostgresPersonalAccessTokenRepository struct {
+	db      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewPostgresPersonalAccessTokenRepository(db *pgxpool.Pool, timeout time.Duration) *PostgresPersonalAccessTokenRepository {
+	return &PostgresPersonalAccessTokenRepository{db: db, timeout: timeout}
+}
+
+// Insert creates a token named name for the user id and returns its plaintext,
+// which cannot be recovered afterwards. expiresAt may be nil for a token that
+// lives until revoked.
+func (t *PostgresPersonalAccessTokenRepository) Insert(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (string, *PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	b := make([]byte, personalAccessTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	plaintext := PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token := PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	query := `insert into personal_access_tokens (user_id, name, token_hash, scopes, created_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := t.db.QueryRow(
+		ctx,
+		query,
+		userID,
+		name,
+		hashPersonalAccessToken(plaintext),
+		strings.Join(scopes, " "),
+		token.CreatedAt,
+		expiresAt,
+	).Scan(&token.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return plaintext, &token, nil
+}
+
+// GetAllByUserID returns the tokens of the user id, expired ones included.
+func (t *PostgresPersonalAccessTokenRepository) GetAllByUserID(ctx context.Context, userID int) ([]*PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	query := `select id, user_id, name, scopes, created_at, last_used_at, expires_at
+	from personal_access_tokens where user_id = $1 order by created_at`
+
+	rows, err := t.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+
+	for rows.Next() {
+		var token PersonalAccessToken
+		var scopes string
+		var lastUsedAt, expiresAt sql.NullTime
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			&scopes,
+			&token.CreatedAt,
+			&lastUsedAt,
+			&expiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		token.Scopes = strings.Fields(scopes)
+		token.LastUsedAt = nullTime(lastUsedAt)
+		token.ExpiresAt = nullTime(expiresAt)
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Authenticate returns the live token matching plaintext, along with the email
+// of its user, and records it as used.
+func (t *PostgresPersonalAccessTokenRepository) Authenticate(ctx context.Context, plaintext string) (*PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	if !strings.HasPrefix(plaintext, PersonalAccessTokenPrefix) {
+		return nil, ErrTokenNotFound
+	}
+
+	query := `select t.id, t.user_id, u.email, t.name, t.scopes, t.created_at, t.last_used_at, t.expires_at
+	from personal_access_tokens t join users u on u.id = t.user_id
+	where t.token_hash = $1 and (t.expires_at is null or t.expires_at > $2)`
+
+	now := time.Now()
+	var token PersonalAccessToken
+	var scopes string
+	var lastUsedAt, expiresAt sql.NullTime
+	err := t.db.QueryRow(ctx, query, hashPersonalAccessToken(plaintext), now).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Email,
+		&token.Name,
+		&scopes,
+		&token.CreatedAt,
+		&lastUsedAt,
+		&expiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	token.LastUsedAt = nullTime(lastUsedAt)
+	token.ExpiresAt = nullTime(expiresAt)
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedResolution {
+		_, err = t.db.Exec(ctx, `update personal_access_tokens set last_used_at = $2 where id = $1`, token.ID, now)
+		if err != nil {
+			return nil, err
+		}
+		token.LastUsedAt = &now
+	}
+
+	return &token, nil
+}
+
+// Revoke deletes the token id of the user id.
+func (t *PostgresPersonalAccessTokenRepository) Revoke(ctx context.Context, userID int, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	result, err := t.db.Exec(ctx, `delete from personal_access_tokens where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+func (t *PostgresPersonalAccessTokenRepository) RevokeAll(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	result, err := t.db.Exec(ctx, `delete from personal_access_tokens where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(result.RowsAffected()), nil
+}
+
+func hashPersonalAccessToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
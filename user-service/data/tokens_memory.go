@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryPersonalAccessTokenRepository keeps the personal access tokens in
+// memory, for tests and running without a database. It looks the emails of
+// the owners up in users, and forgets the tokens of users who are gone. Like
+// a database, it fails once ctx is done.
+type MemoryPersonalAccessTokenRepository struct {
+	users  UserRepository
+	mu     sync.Mutex
+	tokens map[int]PersonalAccessToken
+	// hashes maps the hash of each plaintext to the id of its token.
+	hashes map[string]int
+	nextID int
+}
+
+func NewMemoryPersonalAccessTokenRepository(users UserRepository) *MemoryPersonalAccessTokenRepository {
+	return &MemoryPersonalAccessTokenRepository{
+		users:  users,
+		tokens: map[int]PersonalAccessToken{},
+		hashes: map[string]int{},
+		nextID: 1,
+	}
+}
+
+func (t *MemoryPersonalAccessTokenRepository) Insert(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (string, *PersonalAccessToken, error) {
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, personalAccessTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	plaintext := PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token := PersonalAccessToken{
+		ID:        t.nextID,
+		UserID:    userID,
+		Name:      name,
+		Scopes:    append([]string(nil), scopes...),
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	t.tokens[token.ID] = token
+	t.hashes[hashPersonalAccessToken(plaintext)] = token.ID
+	t.nextID++
+
+	return plaintext, &token, nil
+}
+
+func (t *MemoryPersonalAccessTokenRepository) GetAllByUserID(ctx context.Context, userID int) ([]*PersonalAccessToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tokens := []*PersonalAccessToken{}
+	for _, token := range t.tokens {
+		if token.UserID == userID {
+			token := token
+			tokens = append(tokens, &token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+
+	return tokens, nil
+}
+
+func (t *MemoryPersonalAccessTokenRepository) Authenticate(ctx context.Context, plaintext string) (*PersonalAccessToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(plaintext, PersonalAccessTokenPrefix) {
+		return nil, ErrTokenNotFound
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	token, ok := t.tokens[t.hashes[hashPersonalAccessToken(plaintext)]]
+	if !ok || (token.ExpiresAt != nil && !token.ExpiresAt.After(now)) {
+		return nil, ErrTokenNotFound
+	}
+
+	user, err := t.users.Get(ctx, token.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token.LastUsedAt = &now
+	t.tokens[token.ID] = token
+	token.Email = user.Email
+
+	return &token, nil
+}
+
+func (t *MemoryPersonalAccessTokenRepository) Revoke(ctx context.Context, userID int, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token, ok := t.tokens[id]
+	if !ok || token.UserID != userID {
+		return ErrTokenNotFound
+	}
+	delete(t.tokens, id)
+
+	return nil
+}
+
+func (t *MemoryPersonalAccessTokenRepository) RevokeAll(ctx context.Context, userID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	revoked := 0
+	for id, token := range t.tokens {
+		if token.UserID == userID {
+			delete(t.tokens, id)
+			revoked++
+		}
+	}
+
+	return revoked, nil
+}
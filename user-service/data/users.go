@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// uniqueViolation is the Postgres error code of a unique constraint
+	// violation.
+	uniqueViolation = "23505"
+	// emailIndex keeps emails unique, ignoring case and surrounding spaces.
+	emailIndex = "users_email_key"
+)
+
+// Names of the statements prepared on every connection by PrepareStatements,
+// for the lookups of the login path.
+const (
+	userByEmailStatement = "user_by_email"
+	userByIDStatement    = "user_by_id"
+)
+
+// preparedStatements are the queries PrepareStatements prepares, by name.
+var preparedStatements = map[string]string{
+	userByEmailStatement: `select id, email, first_name, last_name, password, status, created_at, updated_at from users where lower(trim(email)) = $1`,
+	userByIDStatement:    `select id, email, first_name, last_name, password, status, created_at, updated_at from users where id = $1`,
+}
+
+// UserRepository stores the users. Emails are normalized with NormalizeEmail
+// and unique, inserting or updating to a taken one fails with
+// ErrDuplicateEmail. Lookups of a missing user fail with ErrUserNotFound.
+type UserRepository interface {
+	GetAll(ctx context.Context) ([]*User, error)
+	Get(ctx context.Context, id int) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	// Insert stores user, hashing its plaintext password, and returns its id.
+	Insert(ctx context.Context, user User) (int, error)
+	// Update saves the email, names and status of user.
+	Update(ctx context.Context, user *User) error
+	// ResetPassword replaces the password of the user id with password.
+	ResetPassword(ctx context.Context, id int, password string) error
+	Delete(ctx context.Context, id int) error
+}
+
+// PostgresUserRepository stores the users in the users table. Each query is
+// bounded by timeout, on top of the deadline of its context. The connections
+// of its pool have to be set up by PrepareStatements.
+type PostgresUserRepository struct {
+	db      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewPostgresUserRepository(db *pgxpool.Pool, timeout time.Duration) *PostgresUserRepository {
+	return &PostgresUserRepository{db: db, timeout: timeout}
+}
+
+func (r *PostgresUserRepository) GetAll(ctx context.Context) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, password, status, created_at, updated_at
+	from users order by updated_at
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.Status,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			log.Printf("Error scanning %s", err)
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.scanOne(r.db.QueryRow(ctx, userByEmailStatement, NormalizeEmail(email)))
+}
+
+func (r *PostgresUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.scanOne(r.db.QueryRow(ctx, userByIDStatement, id))
+}
+
+func (r *PostgresUserRepository) Update(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	query := `update users set
+		email = $1,
+		first_name = $2,
+		last_name = $3,
+		status = $4,
+		updated_at = $5
+		where id = $6
+	`
+
+	user.Email = NormalizeEmail(user.Email)
+	user.UpdatedAt = time.Now()
+
+	_, err := r.db.Exec(ctx, query, user.Email, user.FirstName, user.LastName, user.Status, user.UpdatedAt, user.ID)
+	if err != nil {
+		return duplicateEmail(err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) Delete(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	query := `delete from users where id = $1`
+
+	_, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) Insert(ctx context.Context, user User) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	hashedPassword, err := hashPassword(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	query := `insert into users (email, first_name, last_name, password, status, created_at, updated_at)
+		values($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = r.db.QueryRow(
+		ctx,
+		query,
+		NormalizeEmail(user.Email),
+		user.FirstName,
+		user.LastName,
+		string(hashedPassword),
+		user.Status,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, duplicateEmail(err)
+	}
+
+	return newID, nil
+}
+
+func (r *PostgresUserRepository) ResetPassword(ctx context.Context, id int, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	query := `update users set password = $1, updated_at = $2 where id = $3`
+	_, err = r.db.Exec(ctx, query, string(hashedPassword), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) scanOne(row pgx.Row) (*User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Status,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// PrepareStatements prepares the user lookups of the login path on conn, so
+// they run without being parsed and planned again. It is meant for the
+// AfterConnect hook of the pool.
+func PrepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for name, query := range preparedStatements {
+		if _, err := conn.Prepare(ctx, name, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// duplicateEmail turns the violation of the unique email index into
+// ErrDuplicateEmail.
+func duplicateEmail(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == emailIndex {
+		return ErrDuplicateEmail
+	}
+
+	return err
+}
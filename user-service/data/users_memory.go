@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryUserRepository keeps the users in memory, for tests and running
+// without a database. Users are copied in and out, so callers never share
+// them with the store. Like a database, it fails once ctx is done.
+type MemoryUserRepository struct {
+	mu     sync.Mutex
+	users  map[int]User
+	nextID int
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[int]User{}, nextID: 1}
+}
+
+func (r *MemoryUserRepository) GetAll(ctx context.Context) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []*User
+	for _, user := range r.users {
+		user := user
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UpdatedAt.Before(users[j].UpdatedAt) })
+
+	return users, nil
+}
+
+func (r *MemoryUserRepository) Get(ctx context.Context, id int) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	email = NormalizeEmail(email)
+	for _, user := range r.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+
+	return nil, ErrUserNotFound
+}
+
+func (r *MemoryUserRepository) Insert(ctx context.Context, user User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := hashPassword(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user.Email = NormalizeEmail(user.Email)
+	if r.emailTaken(user.Email, 0) {
+		return 0, ErrDuplicateEmail
+	}
+
+	user.ID = r.nextID
+	user.Password = string(hashedPassword)
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	r.users[user.ID] = user
+	r.nextID++
+
+	return user.ID, nil
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[user.ID]
+	if !ok {
+		return nil
+	}
+
+	user.Email = NormalizeEmail(user.Email)
+	if r.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+	user.UpdatedAt = time.Now()
+
+	stored.Email = user.Email
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.Status = user.Status
+	stored.UpdatedAt = user.UpdatedAt
+	r.users[user.ID] = stored
+
+	return nil
+}
+
+func (r *MemoryUserRepository) ResetPassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[id]; ok {
+		user.Password = string(hashedPassword)
+		user.UpdatedAt = time.Now()
+		r.users[id] = user
+	}
+
+	return nil
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+
+	return nil
+}
+
+// emailTaken reports whether a user other than the id has email.
+func (r *MemoryUserRepository) emailTaken(email string, id int) bool {
+	for _, user := range r.users {
+		if user.Email == email && user.ID != id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Statuses of a user. Accounts are pending from signup until their email is
+// verified.
+const (
+	UserStatusPending = "pending"
+	UserStatusActive  = "active"
+)
+
+const verificationTokenBytes = 32
+
+var ErrVerificationNotFound = errors.New("invalid or expired verification token")
+
+// EmailVerificationRepository holds the tokens mailed to users to prove they
+// own the email they signed up with. Only a hash of each token is stored, and
+// a token works once.
+type EmailVerificationRepository interface {
+	Create(ctx context.Context, userID int, ttl time.Duration) (string, error)
+	LastSent(ctx context.Context, userID int) (time.Time, error)
+	// Verify fails with ErrVerificationNotFound when token is unknown or
+	// expired.
+	Verify(ctx context.Context, token string) (int, error)
+}
+
+// PostgresEmailVerificationRepository stores the tokens in the
+// email_verifications table. Each query is bounded by timeout, on top of the
+// deadline of its context.
+type PostgresEmailVerificationRepository struct {
+	db      *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewPostgresEmailVerificationRepository(db *pgxpool.Pool, timeout time.Duration) *PostgresEmailVerificationRepository {
+	return &PostgresEmailVerificationRepository{db: db, timeout: timeout}
+}
+
+// Create issues a verification token for the user id, valid for ttl, and
+// returns its plaintext.
+func (v *PostgresEmailVerificationRepository) Create(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	b := make([]byte, verificationTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	// drop expired tokens while we are at it
+	_, err := v.db.Exec(ctx, `delete from email_verifications where expires_at < $1`, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	query := `insert into email_verifications (token_hash, user_id, expires_at, created_at)
+		values ($1, $2, $3, $4)`
+
+	now := time.Now()
+	_, err = v.db.Exec(ctx, query, hashChallenge(token), userID, now.Add(ttl), now)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// LastSent returns when the latest token of the user id was issued, or the
+// zero time when none is live.
+func (v *PostgresEmailVerificationRepository) LastSent(ctx context.Context, userID int) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	query := `select max(created_at) from email_verifications where user_id = $1 and expires_at > $2`
+
+	var last sql.NullTime
+	err := v.db.QueryRow(ctx, query, userID, time.Now()).Scan(&last)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return last.Time, nil
+}
+
+// Verify consumes token and activates the user it was issued to, whose other
+// tokens are dropped, and returns the id of the user.
+func (v *PostgresEmailVerificationRepository) Verify(ctx context.Context, token string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	tx, err := v.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	query := `delete from email_verifications where token_hash = $1 and expires_at > $2 returning user_id`
+	err = tx.QueryRow(ctx, query, hashChallenge(token), time.Now()).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrVerificationNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `update users set status = $1, updated_at = $2 where id = $3 and status = $4`,
+		UserStatusActive, time.Now(), userID, UserStatusPending)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `delete from email_verifications where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// memoryToken is a token of MemoryEmailVerificationRepository or
+// MemoryPasswordResetRepository.
+type memoryToken struct {
+	userID    int
+	expiresAt time.Time
+	createdAt time.Time
+}
+
+// memoryTokens holds single use tokens by the hash of their plaintext.
+type memoryTokens struct {
+	mu     sync.Mutex
+	tokens map[string]memoryToken
+}
+
+func (m *memoryTokens) create(ctx context.Context, userID int, ttl time.Duration, size int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = map[string]memoryToken{}
+	}
+	now := time.Now()
+	m.tokens[hashChallenge(token)] = memoryToken{userID: userID, expiresAt: now.Add(ttl), createdAt: now}
+
+	return token, nil
+}
+
+func (m *memoryTokens) lastSent(ctx context.Context, userID int) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last time.Time
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.userID == userID && token.expiresAt.After(now) && token.createdAt.After(last) {
+			last = token.createdAt
+		}
+	}
+
+	return last, nil
+}
+
+// consume drops token with the other tokens of its user and returns the id of
+// that user, or notFound.
+func (m *memoryTokens) consume(ctx context.Context, token string, notFound error) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.tokens[hashChallenge(token)]
+	if !ok || !stored.expiresAt.After(time.Now()) {
+		return 0, notFound
+	}
+
+	for hash, t := range m.tokens {
+		if t.userID == stored.userID {
+			delete(m.tokens, hash)
+		}
+	}
+
+	return stored.userID, nil
+}
+
+// MemoryEmailVerificationRepository keeps the verification tokens in memory,
+// for tests and running without a database, and activates the users in
+// users. Like a database, it fails once ctx is done.
+type MemoryEmailVerificationRepository struct {
+	users  UserRepository
+	tokens memoryTokens
+}
+
+func NewMemoryEmailVerificationRepository(users UserRepository) *MemoryEmailVerificationRepository {
+	return &MemoryEmailVerificationRepository{users: users}
+}
+
+func (v *MemoryEmailVerificationRepository) Create(ctx context.Context, userID int, ttl time.Duration) (string, error) {
+	return v.tokens.create(ctx, userID, ttl, verificationTokenBytes)
+}
+
+func (v *MemoryEmailVerificationRepository) LastSent(ctx context.Context, userID int) (time.Time, error) {
+	return v.tokens.lastSent(ctx, userID)
+}
+
+func (v *MemoryEmailVerificationRepository) Verify(ctx context.Context, token string) (int, error) {
+	userID, err := v.tokens.consume(ctx, token, ErrVerificationNotFound)
+	if err != nil {
+		return 0, err
+	}
+
+	user, err := v.users.Get(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if user.Status == UserStatusPending {
+		user.Status = UserStatusActive
+		if err := v.users.Update(ctx, user); err != nil {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
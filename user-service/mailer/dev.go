@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LogMailer prints messages to the log instead of sending them, for local
+// development. Links in the messages are printed too, never use it in
+// production.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if _, err := format(m.From, msg); err != nil {
+		return err
+	}
+
+	log.Printf("[Mail] to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+
+	return nil
+}
+
+// FileMailer writes each message to its own .eml file in Dir, where mail
+// clients can open it.
+type FileMailer struct {
+	From string
+	Dir  string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	content, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o750); err != nil {
+		return err
+	}
+
+	// keep the recipient in the name so a mailbox is easy to find
+	recipient := strings.NewReplacer("/", "_", "\\", "_", "<", "", ">", "", " ", "").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), recipient)
+
+	return os.WriteFile(filepath.Join(m.Dir, name), content, 0o640)
+}
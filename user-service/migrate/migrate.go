@@ -0,0 +1,294 @@
+// Package migrate evolves the database schema of the user service with the
+// ordered migrations embedded in the binary. Each migration is a pair of
+// files in sql/, NNNN_name.up.sql and NNNN_name.down.sql, applied in a
+// transaction of its own and recorded in the schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockKey names the advisory lock held while migrating, so replicas starting
+// together do not race. Any constant works as long as nothing else uses it.
+const lockKey int64 = 0x75736572_6d696772 // "usermigr"
+
+const createTable = `create table if not exists schema_migrations (
+	version bigint not null primary key,
+	name character varying(255) not null,
+	applied_at timestamp without time zone not null
+)`
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+var ErrNoDownMigration = errors.New("migrate: migration cannot be rolled back")
+
+// Migration is one step of the schema.
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// Status tells whether a migration was applied. Unknown migrations were
+// applied by a newer build that knows more of them than this one.
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+	Unknown   bool       `json:"unknown,omitempty"`
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+}
+
+// New returns a migrator of db using the migrations embedded in the binary.
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := load(embedded, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order and returns those it applied.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	var applied []*Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			err := run(ctx, conn, migration, migration.up,
+				`insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+				migration.Version, migration.Name, time.Now())
+			if err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and
+// returns those it rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	var rolledBack []*Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if migration.down == "" {
+				return fmt.Errorf("%w: %04d_%s has no down file", ErrNoDownMigration, migration.Version, migration.Name)
+			}
+
+			err := run(ctx, conn, migration, migration.down,
+				`delete from schema_migrations where version = $1`, migration.Version)
+			if err != nil {
+				return err
+			}
+			rolledBack = append(rolledBack, migration)
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Status lists every known migration, and any applied one this build does
+// not know, by version.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	var statuses []*Status
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := &Status{Version: migration.Version, Name: migration.Name}
+			if applied, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &applied.at
+				delete(versions, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+
+		for version, applied := range versions {
+			at := applied.at
+			statuses = append(statuses, &Status{Version: version, Name: applied.name, AppliedAt: &at, Unknown: true})
+		}
+
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// locked runs fn on a connection holding the migration lock, waiting for
+// other migrators to finish first.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// session locks belong to the connection, which is why a single one is used
+	if _, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("migrate: taking lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey)
+
+	if _, err = conn.ExecContext(ctx, createTable); err != nil {
+		return fmt.Errorf("migrate: creating schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+type appliedMigration struct {
+	name string
+	at   time.Time
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `select version, name, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := map[int64]appliedMigration{}
+	for rows.Next() {
+		var version int64
+		var applied appliedMigration
+		if err := rows.Scan(&version, &applied.name, &applied.at); err != nil {
+			return nil, err
+		}
+		versions[version] = applied
+	}
+
+	return versions, rows.Err()
+}
+
+// run executes script and the bookkeeping statement in one transaction.
+func run(ctx context.Context, conn *sql.Conn, migration *Migration, script string, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migrate: %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return fmt.Errorf("migrate: recording %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+// load reads the migrations in dir of fsys, ordered by version.
+func load(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+
+		base, direction, ok := cutSuffix(file)
+		if !ok {
+			return nil, fmt.Errorf("migrate: %s is not named NNNN_name.up.sql or NNNN_name.down.sql", file)
+		}
+		number, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(number, 10, 64)
+		if !ok || err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("migrate: %s is not named NNNN_name.up.sql or NNNN_name.down.sql", file)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migrate: version %d is used by both %s and %s", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" {
+			return nil, fmt.Errorf("migrate: %04d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func cutSuffix(file string) (string, string, bool) {
+	for _, direction := range []string{"up", "down"} {
+		suffix := "." + direction + ".sql"
+		if strings.HasSuffix(file, suffix) {
+			return strings.TrimSuffix(file, suffix), direction, true
+		}
+	}
+
+	return "", "", false
+}
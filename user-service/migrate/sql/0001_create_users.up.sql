@@ -0,0 +1,18 @@
+-- Databases set up by the old init script already have these objects, so
+-- this baseline only creates what is missing.
+
+CREATE SEQUENCE IF NOT EXISTS user_id_seq;
+
+CREATE TABLE IF NOT EXISTS users (
+    id integer DEFAULT nextval('user_id_seq'::regclass) NOT NULL,
+    email character varying(255),
+    first_name character varying(255),
+    last_name character varying(255),
+    password character varying(60),
+    status character varying(60),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT users_pkey PRIMARY KEY (id)
+);
+
+ALTER SEQUENCE user_id_seq OWNED BY users.id;
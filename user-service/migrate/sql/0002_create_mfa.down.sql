@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS mfa_challenges;
+
+DROP TABLE IF EXISTS user_recovery_codes;
+
+DROP TABLE IF EXISTS user_mfa;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id integer NOT NULL,
+    secret character varying(64) NOT NULL,
+    enabled boolean DEFAULT false NOT NULL,
+    last_used_step bigint DEFAULT 0 NOT NULL,
+    created_at timestamp without time zone,
+    confirmed_at timestamp without time zone,
+    CONSTRAINT user_mfa_pkey PRIMARY KEY (user_id),
+    CONSTRAINT user_mfa_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id serial NOT NULL,
+    user_id integer NOT NULL,
+    code_hash character(64) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone,
+    CONSTRAINT user_recovery_codes_pkey PRIMARY KEY (id),
+    CONSTRAINT user_recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS user_recovery_codes_user_id_idx ON user_recovery_codes USING btree (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    token_hash character(64) NOT NULL,
+    user_id integer NOT NULL,
+    device character varying(255),
+    attempts integer DEFAULT 0 NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone,
+    CONSTRAINT mfa_challenges_pkey PRIMARY KEY (token_hash),
+    CONSTRAINT mfa_challenges_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id serial NOT NULL,
+    user_id integer NOT NULL,
+    name character varying(255) NOT NULL,
+    token_hash character(64) NOT NULL,
+    scopes character varying(255) NOT NULL,
+    created_at timestamp without time zone,
+    last_used_at timestamp without time zone,
+    expires_at timestamp without time zone,
+    CONSTRAINT personal_access_tokens_pkey PRIMARY KEY (id),
+    CONSTRAINT personal_access_tokens_token_hash_key UNIQUE (token_hash),
+    CONSTRAINT personal_access_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
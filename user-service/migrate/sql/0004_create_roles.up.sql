@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id serial NOT NULL,
+    name character varying(60) NOT NULL,
+    description character varying(255),
+    CONSTRAINT roles_pkey PRIMARY KEY (id),
+    CONSTRAINT roles_name_key UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id serial NOT NULL,
+    name character varying(60) NOT NULL,
+    CONSTRAINT permissions_pkey PRIMARY KEY (id),
+    CONSTRAINT permissions_name_key UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id integer NOT NULL,
+    permission_id integer NOT NULL,
+    CONSTRAINT role_permissions_pkey PRIMARY KEY (role_id, permission_id),
+    CONSTRAINT role_permissions_role_id_fkey FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    CONSTRAINT role_permissions_permission_id_fkey FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id integer NOT NULL,
+    role_id integer NOT NULL,
+    granted_at timestamp without time zone,
+    CONSTRAINT user_roles_pkey PRIMARY KEY (user_id, role_id),
+    CONSTRAINT user_roles_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT user_roles_role_id_fkey FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS role_audit_log (
+    id serial NOT NULL,
+    actor character varying(255) NOT NULL,
+    user_id integer NOT NULL,
+    role character varying(60) NOT NULL,
+    action character varying(10) NOT NULL,
+    created_at timestamp without time zone,
+    CONSTRAINT role_audit_log_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS role_audit_log_user_id_idx ON role_audit_log USING btree (user_id);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Manages users, roles and account security'),
+    ('moderator', 'Reviews users and their content')
+    ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name) VALUES
+    ('users:read'),
+    ('users:delete'),
+    ('roles:manage'),
+    ('mfa:reset'),
+    ('lockouts:clear'),
+    ('content:moderate')
+    ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+    WHERE r.name = 'admin'
+       OR (r.name = 'moderator' AND p.name IN ('users:read', 'content:moderate', 'lockouts:clear'))
+    ON CONFLICT DO NOTHING;
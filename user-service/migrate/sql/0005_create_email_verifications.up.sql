@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash character(64) NOT NULL,
+    user_id integer NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone,
+    CONSTRAINT email_verifications_pkey PRIMARY KEY (token_hash),
+    CONSTRAINT email_verifications_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS email_verifications_user_id_idx ON email_verifications USING btree (user_id);
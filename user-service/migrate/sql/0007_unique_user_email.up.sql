@@ -0,0 +1,4 @@
+-- Emails are compared ignoring case, as the authentication service does.
+-- Fails when the table already holds duplicates, which have to be merged by
+-- hand first.
+CREATE UNIQUE INDEX users_email_key ON users USING btree (lower(email));
@@ -0,0 +1,2 @@
+-- The original spelling of the emails is gone, normalized ones work either way.
+SELECT 1;
@@ -0,0 +1,3 @@
+-- Emails are stored lower case from now on. The unique index on lower(email)
+-- guarantees this cannot collide.
+UPDATE users SET email = lower(email) WHERE email <> lower(email);
@@ -0,0 +1,3 @@
+-- The surrounding spaces are gone, trimmed emails are unique either way.
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users USING btree (lower(email));
@@ -0,0 +1,7 @@
+-- Emails are stored trimmed as well from now on, and kept unique in that form,
+-- as the authentication service compares them. Fails when the table already
+-- holds emails that only differ in surrounding spaces, which have to be merged
+-- by hand first.
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users USING btree (lower(trim(email)));
@@ -0,0 +1,74 @@
+package securecookie
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, encryptionKey := range []string{"", "encryption key"} {
+		codec, err := New("signing key", encryptionKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded, err := codec.Encode("session", "token value")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encryptionKey != "" && strings.Contains(encoded, "dG9rZW4gdmFsdWU") {
+			t.Error("encrypted cookie carries the plain value")
+		}
+
+		got, err := codec.Decode("session", encoded)
+		if err != nil || got != "token value" {
+			t.Errorf("Decode() = %q, %v, want the encoded value", got, err)
+		}
+	}
+}
+
+func TestCodecTamper(t *testing.T) {
+	for _, encryptionKey := range []string{"", "encryption key"} {
+		codec, _ := New("signing key", encryptionKey)
+		otherKey, _ := New("other signing key", encryptionKey)
+		encoded, _ := codec.Encode("session", "token value")
+		payload, signature, _ := strings.Cut(encoded, ".")
+		forged, _ := otherKey.Encode("session", "token value")
+
+		tests := []struct {
+			name    string
+			cookie  string
+			encoded string
+		}{
+			{"payload changed", "session", flip(payload) + "." + signature},
+			{"signature changed", "session", payload + "." + flip(signature)},
+			{"signature dropped", "session", payload},
+			{"signature empty", "session", payload + "."},
+			{"moved to another cookie", "csrf", encoded},
+			{"signed with another key", "session", forged},
+			{"not base64", "session", "!!!." + signature},
+			{"empty", "session", ""},
+		}
+
+		for _, tt := range tests {
+			if got, err := codec.Decode(tt.cookie, tt.encoded); err != ErrInvalidValue {
+				t.Errorf("encryption %v, %s: Decode() = %q, %v, want %v", encryptionKey != "", tt.name, got, err, ErrInvalidValue)
+			}
+		}
+	}
+}
+
+func TestNewRequiresSigningKey(t *testing.T) {
+	if _, err := New("", "encryption key"); err != ErrNoSigningKey {
+		t.Errorf("New() without a signing key = %v, want %v", err, ErrNoSigningKey)
+	}
+}
+
+// flip changes the first character of s to another base64url one.
+func flip(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+
+	return "A" + s[1:]
+}
@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 6238 test vectors, the ASCII
+// string 12345678901234567890, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// the vectors of RFC 6238 appendix B, cut to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code with an invalid secret succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, code(current), current, true},
+		{"previous step", rfcSecret, code(current - 1), current - 1, true},
+		{"next step", rfcSecret, code(current + 1), current + 1, true},
+		{"beyond the skew", rfcSecret, code(current - 2), 0, false},
+		{"spaces", rfcSecret, code(current)[:3] + " " + code(current)[3:], current, true},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code(current), current, true},
+		{"too short", rfcSecret, code(current)[:5], 0, false},
+		{"wrong code", rfcSecret, "000000", 0, false},
+		{"invalid secret", "not base32!", code(current), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now)
+			if step != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != secretBytes {
+		t.Fatalf("secret %q decodes to %d bytes, %v, want %d", secret, len(key), err, secretBytes)
+	}
+
+	other, _ := GenerateSecret()
+	if other == secret {
+		t.Error("two secrets are the same")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Example Co", "bob@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Example Co:bob@example.com" {
+		t.Errorf("URI = %s, want an otpauth://totp/ URI labelled with the issuer and account", uri)
+	}
+
+	query := uri.Query()
+	for key, want := range map[string]string{"secret": rfcSecret, "issuer": "Example Co", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := query.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}